@@ -0,0 +1,73 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"os"
+)
+
+// AgeExtension is the extension of the resulting object encrypted client-side
+const AgeExtension = ".age"
+
+// ageRecipients are the recipients to whom the resulting object is encrypted client-side, if any
+var ageRecipients []age.Recipient
+
+// Parse the age recipients of encrypt-recipient and encrypt-recipients-file
+func parseRecipients() error {
+	for _, recipient := range encryptRecipients {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return fmt.Errorf("encrypt-recipient is invalid: %v", err)
+		}
+		ageRecipients = append(ageRecipients, r)
+	}
+	if encryptRecipientsFile != "" {
+		f, err := os.Open(encryptRecipientsFile)
+		if err != nil {
+			return fmt.Errorf("encrypt-recipients-file is invalid: %v", err)
+		}
+		defer f.Close()
+		recipients, err := age.ParseRecipients(f)
+		if err != nil {
+			return fmt.Errorf("encrypt-recipients-file is invalid: %v", err)
+		}
+		ageRecipients = append(ageRecipients, recipients...)
+	}
+	return nil
+}
+
+// Return whether the resulting object is encrypted client-side
+func clientEncrypted() bool {
+	return len(ageRecipients) > 0
+}
+
+// encryptedEncoder encrypts the output of another encoder, once compressed
+type encryptedEncoder struct {
+	encoder
+	e io.WriteCloser
+}
+
+func (e *encryptedEncoder) close() error {
+	if err := e.encoder.close(); err != nil {
+		return err
+	}
+	return e.e.Close()
+}
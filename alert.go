@@ -0,0 +1,123 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPPasswordEnvVar is the environment variable of the smtp-password when the flag is not given
+const SMTPPasswordEnvVar = "OBJECT_APPENDER_SMTP_PASSWORD"
+
+// Check that failure reports can be sent by email, for scheduled runs only
+func validateAlert() error {
+	if alertEmail == "" {
+		return nil
+	}
+	if schedule == "" {
+		return errors.New("alert-email requires schedule")
+	}
+	if smtpHost == "" {
+		return errors.New("alert-email requires smtp-host")
+	}
+	if smtpFrom == "" && !strings.Contains(smtpUser, "@") {
+		return errors.New("alert-email requires smtp-from, unless smtp-user is an address")
+	}
+	return nil
+}
+
+// Return the recipients of the failure reports
+func alertRecipients() []string {
+	var to []string
+	for _, address := range strings.Split(alertEmail, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			to = append(to, address)
+		}
+	}
+	return to
+}
+
+// Return how to resume after the run failed
+func resumeHint() string {
+	switch {
+	case savedParts > 0 && resume:
+		return fmt.Sprintf("The next scheduled run resumes from the checkpoint saved after %v parts.", savedParts)
+	case savedParts > 0:
+		return fmt.Sprintf("Run once with the same options and --resume to continue from the checkpoint saved after %v parts, "+
+			"before the next scheduled run starts over.", savedParts)
+	case incremental:
+		return "The next scheduled run appends the objects after the last watermark saved. Run once with the same " +
+			"options to catch up now."
+	default:
+		return "The next scheduled run appends the source objects again. Run once with the same options to retry now."
+	}
+}
+
+// Email a failure report of the scheduled run started at start that failed with err to alert-email, with the
+// last key processed and how to resume, so that failures are noticed without a paging stack
+func alertFailure(start time.Time, err error) {
+	if alertEmail == "" {
+		return
+	}
+	host, _ := os.Hostname()
+	last := lastObject()
+	if last == "" {
+		last = "none"
+	}
+	from := smtpFrom
+	if from == "" {
+		from = smtpUser
+	}
+	to := alertRecipients()
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\n", from, strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: object-appender run failed: %s\r\n", path.Join(sourceBucket, sourcePrefix))
+	fmt.Fprintf(&b, "Date: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "The run %s on %s, started at %s, failed after %s.\r\n\r\n", runID, host, start.Format(time.RFC3339), time.Since(start).Round(time.Second))
+	fmt.Fprintf(&b, "Error: %v\r\n", err)
+	fmt.Fprintf(&b, "Last key processed: %s\r\n", last)
+	fmt.Fprintf(&b, "Objects appended: %v (%v bytes)\r\n\r\n", objectCount, objectSize)
+	fmt.Fprintf(&b, "To resume: %s\r\n", resumeHint())
+	fmt.Fprintf(&b, "Next run at: %s\r\n", cronSchedule.Next(time.Now()).Format(time.RFC3339))
+
+	var auth smtp.Auth
+	if smtpUser != "" {
+		password := smtpPassword
+		if password == "" {
+			password = os.Getenv(SMTPPasswordEnvVar)
+		}
+		auth = smtp.PlainAuth("", smtpUser, password, smtpHost)
+	}
+	// STARTTLS is used when the server offers it
+	addr := net.JoinHostPort(smtpHost, strconv.Itoa(smtpPort))
+	if err := smtp.SendMail(addr, auth, from, to, []byte(b.String())); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to email failure report to %v - %v", alertEmail, err), "to", alertEmail, "error", err.Error())
+		return
+	}
+	log.Printf("Emailed failure report to %s\n", alertEmail)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mcAlias is an alias of the mc config file
+type mcAlias struct {
+	URL       string `json:"url"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	API       string `json:"api"`
+	Path      string `json:"path"`
+}
+
+// mcConfig is the mc config file, listing the aliases configured by mc alias set
+type mcConfig struct {
+	Version string             `json:"version"`
+	Aliases map[string]mcAlias `json:"aliases"`
+}
+
+// Return the default mc config directory
+func defaultMcConfigDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".mc"
+	}
+	return filepath.Join(home, ".mc")
+}
+
+// Resolve the endpoint, credentials and TLS settings of the alias from the mc config file.
+// Flags given take precedence, and the alias is stripped from the front of bucket/prefixes of the form alias/bucket/prefix.
+func resolveAlias() error {
+	data, err := os.ReadFile(filepath.Join(mcConfigDir, "config.json"))
+	if err != nil {
+		return err
+	}
+	var config mcConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	given := givenFlags()
+	a, ok := config.Aliases[alias]
+	if !ok {
+		return fmt.Errorf("alias %q not found in %v", alias, mcConfigDir)
+	}
+
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return err
+	}
+	if endpoint == "" {
+		endpoint = u.Host
+		secure = u.Scheme != "http"
+	}
+	if accessKey == "" && secretKey == "" {
+		accessKey, secretKey = a.AccessKey, a.SecretKey
+	}
+	if !given["signature"] && a.API == "S3v2" {
+		signature = "v2"
+	}
+	if !given["lookup-style"] {
+		switch a.Path {
+		case "on":
+			lookupStyle = "path"
+		case "off":
+			lookupStyle = "dns"
+		}
+	}
+
+	sourceBucketPrefix = strings.TrimPrefix(sourceBucketPrefix, alias+"/")
+	targetBucketPrefix = strings.TrimPrefix(targetBucketPrefix, alias+"/")
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"log"
+	"log/slog"
+	"path"
+)
+
+// Check that append-to can be combined with the other options, as the appended contents must remain valid
+// when concatenated to the existing object
+func validateAppend() error {
+	if appendTo == "" {
+		return nil
+	}
+	if format != "" && format != GzipMembersFormat && format != "ndjson" {
+		return errors.New("append-to cannot be combined with format " + format)
+	}
+	if clientEncrypted() {
+		return errors.New("append-to cannot be combined with client-side encryption")
+	}
+	if outputChecksum {
+		return errors.New("append-to cannot be combined with output-checksum")
+	}
+	if targetNameText != DefaultTargetNameTemplate || ifNoneMatch != "" {
+		return errors.New("append-to cannot be combined with target-name-template, name-by-hash or if-none-match")
+	}
+	return nil
+}
+
+// Return the name of the append-to object under the target prefix
+func appendObjectName() string {
+	return path.Join(targetPrefix, appendTo)
+}
+
+// Extend the append-to object with the resulting object uploaded under a temporary name, composing them on the
+// server. The append-to object is created when it does not exist yet, and is copied through the client when it
+// is too small to be composed.
+func extendTarget(ctx context.Context, s *s3Sink) error {
+	name := appendObjectName()
+	existing, err := s.client.StatObject(ctx, targetBucket, name, minio.StatObjectOptions{ServerSideEncryption: targetReadEncryption()})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		log.Printf("Creating %s\n", name)
+		return s.rename(ctx, targetObjectName, name)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to stat object %v - %v", name, err), "object", name, "error", err.Error())
+		return err
+	}
+
+	log.Printf("Appending %s to %s\n", targetObjectName, name)
+	if existing.Size < MinComposePartSize {
+		err = copyAppend(ctx, s, existing)
+	} else {
+		// The existing object must be unchanged since it was stat'ed
+		dst := minio.CopyDestOptions{Bucket: targetBucket, Object: name, UserMetadata: copyMetadata(), ReplaceMetadata: true, UserTags: targetTags, ReplaceTags: true, Encryption: targetEncryption}
+		retainCopy(&dst, false)
+		_, err = s.client.ComposeObject(ctx, dst,
+			minio.CopySrcOptions{Bucket: targetBucket, Object: name, MatchETag: existing.ETag, Encryption: targetReadEncryption()},
+			minio.CopySrcOptions{Bucket: targetBucket, Object: targetObjectName, Encryption: targetReadEncryption()})
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to append to object %v - %v", name, err), "object", name, "error", err.Error())
+		return err
+	}
+	if err = s.client.RemoveObject(ctx, targetBucket, targetObjectName, minio.RemoveObjectOptions{}); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to remove object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+	}
+	log.Printf("Successfully appended to %s\n", name)
+	targetObjectName = name
+	return nil
+}
+
+// Rewrite the existing append-to object followed by the resulting object through the client
+func copyAppend(ctx context.Context, s *s3Sink, existing minio.ObjectInfo) error {
+	headOpts := minio.GetObjectOptions{ServerSideEncryption: targetReadEncryption()}
+	if err := headOpts.SetMatchETag(existing.ETag); err != nil {
+		return err
+	}
+	head, err := s.client.GetObject(ctx, targetBucket, existing.Key, headOpts)
+	if err != nil {
+		return err
+	}
+	defer head.Close()
+	tail, err := s.client.GetObject(ctx, targetBucket, targetObjectName, minio.GetObjectOptions{ServerSideEncryption: targetReadEncryption()})
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+	opts := minio.PutObjectOptions{ContentType: contentType(), ContentEncoding: contentEncoding(), UserMetadata: targetMetadata(), UserTags: targetTags, StorageClass: storageClass, PartSize: partSize, ServerSideEncryption: targetEncryption}
+	retainPut(&opts, false)
+	_, err = s.client.PutObject(ctx, targetBucket, existing.Key, io.MultiReader(head, tail), -1, opts)
+	return err
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Environment variables read, in order, for credentials not given as flags
+var (
+	AccessKeyEnvVars    = []string{"AWS_ACCESS_KEY_ID", "MINIO_ACCESS_KEY", "MINIO_ROOT_USER"}
+	SecretKeyEnvVars    = []string{"AWS_SECRET_ACCESS_KEY", "MINIO_SECRET_KEY", "MINIO_ROOT_PASSWORD"}
+	SessionTokenEnvVars = []string{"AWS_SESSION_TOKEN", "MINIO_SESSION_TOKEN"}
+)
+
+// connection is the endpoint and credentials with which to connect to the source or the target
+type connection struct {
+	endpoint, accessKey, secretKey, sessionToken string
+}
+
+// Return the connection, with the endpoint and credentials not given taken from defaults
+func (c connection) orDefault(defaults connection) connection {
+	if c.endpoint == "" {
+		c.endpoint = defaults.endpoint
+	}
+	if c.accessKey == "" && c.secretKey == "" {
+		c.accessKey, c.secretKey, c.sessionToken = defaults.accessKey, defaults.secretKey, defaults.sessionToken
+	}
+	return c
+}
+
+// Fill in the credentials not given as flags from the environment
+func credentialsFromEnv() {
+	if accessKey == "" {
+		accessKey = firstEnv(AccessKeyEnvVars)
+	}
+	if secretKey == "" {
+		secretKey = firstEnv(SecretKeyEnvVars)
+	}
+	if sessionToken == "" {
+		sessionToken = firstEnv(SessionTokenEnvVars)
+	}
+	if webIdentityTokenFile == "" {
+		webIdentityTokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if roleARN == "" && webIdentityTokenFile != "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+}
+
+// Return the value of the first of the environment variables that is set
+func firstEnv(names []string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Return the credentials used to connect to the s3 endpoint. With web-identity-token-file, temporary credentials
+// are obtained with the token in the file, re-read on every refresh. With role-arn, temporary credentials of the role
+// are obtained with the credentials given. Temporary credentials are refreshed when they expire. With credential-chain, the credentials
+// given are tried first, followed by the environment, the shared credentials files and the instance metadata.
+func newCredentials(tr http.RoundTripper, c connection) (*credentials.Credentials, error) {
+	stsURL := stsEndpoint
+	if stsURL == "" {
+		stsURL = "https://" + c.endpoint
+		if !secure {
+			stsURL = "http://" + c.endpoint
+		}
+	}
+	if webIdentityTokenFile != "" {
+		return credentials.New(&credentials.STSWebIdentity{
+			Client:      &http.Client{Transport: tr},
+			STSEndpoint: stsURL,
+			RoleARN:     roleARN,
+			GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+				token, err := os.ReadFile(webIdentityTokenFile)
+				if err != nil {
+					return nil, err
+				}
+				return &credentials.WebIdentityToken{Token: strings.TrimSpace(string(token))}, nil
+			},
+		}), nil
+	}
+	if roleARN != "" {
+		if c.accessKey == "" || c.secretKey == "" {
+			return nil, errors.New("role-arn requires an access key and secret key")
+		}
+		return credentials.New(&credentials.STSAssumeRole{
+			Client:      &http.Client{Transport: tr},
+			STSEndpoint: stsURL,
+			Options: credentials.STSAssumeRoleOptions{
+				AccessKey:       c.accessKey,
+				SecretKey:       c.secretKey,
+				RoleARN:         roleARN,
+				RoleSessionName: roleSessionName,
+				Location:        region,
+			},
+		}), nil
+	}
+	if signature == "v2" {
+		return credentials.NewStaticV2(c.accessKey, c.secretKey, c.sessionToken), nil
+	}
+	if !credentialChain {
+		return credentials.NewStaticV4(c.accessKey, c.secretKey, c.sessionToken), nil
+	}
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.Static{Value: credentials.Value{
+			AccessKeyID:     c.accessKey,
+			SecretAccessKey: c.secretKey,
+			SessionToken:    c.sessionToken,
+			SignerType:      credentials.SignatureV4,
+		}},
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.FileMinioClient{},
+		&credentials.IAM{Client: &http.Client{Transport: tr}},
+	}), nil
+}
@@ -0,0 +1,318 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"sort"
+	"strings"
+)
+
+// AvroMagic starts every Avro object container file
+var AvroMagic = []byte("Obj\x01")
+
+// avroHeader is the header of an Avro object container file
+type avroHeader struct {
+	metadata map[string][]byte
+	sync     []byte
+}
+
+// avroEncoder merges Avro object container files sharing a schema and codec into a single container file,
+// copying their data blocks unchanged under the header of the first file
+type avroEncoder struct {
+	w           io.Writer
+	sync        []byte
+	fingerprint [sha256.Size]byte
+	codec       string
+}
+
+func (e *avroEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+	br := bufio.NewReader(counter)
+	if _, err := br.Peek(1); err == io.EOF {
+		// Empty objects have no header
+		return counter.n, nil
+	}
+	header, err := readAvroHeader(br)
+	if err != nil {
+		return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+	}
+	fingerprint, err := avroFingerprint(header.metadata["avro.schema"])
+	if err != nil {
+		return counter.n, fmt.Errorf("object %v: invalid schema: %w", object.Key, err)
+	}
+	codec := string(header.metadata["avro.codec"])
+	if codec == "" {
+		codec = "null"
+	}
+
+	if e.sync == nil {
+		e.sync, e.fingerprint, e.codec = header.sync, fingerprint, codec
+		if err := writeAvroHeader(e.w, header); err != nil {
+			return counter.n, err
+		}
+	} else if fingerprint != e.fingerprint {
+		return counter.n, fmt.Errorf("object %v schema fingerprint %x does not match schema fingerprint %x", object.Key, fingerprint, e.fingerprint)
+	} else if codec != e.codec {
+		return counter.n, fmt.Errorf("object %v codec %v does not match codec %v", object.Key, codec, e.codec)
+	}
+
+	// Copy the data blocks, each followed by the sync marker of its file
+	for {
+		count, err := readAvroLong(br)
+		if err == io.EOF {
+			return counter.n, nil
+		}
+		if err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+		size, err := readAvroLong(br)
+		if err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+		block := binary.AppendVarint(binary.AppendVarint(nil, count), size)
+		if _, err := e.w.Write(block); err != nil {
+			return counter.n, err
+		}
+		if _, err := io.CopyN(e.w, br, size); err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+		sync := make([]byte, len(header.sync))
+		if _, err := io.ReadFull(br, sync); err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+		if !bytes.Equal(sync, header.sync) {
+			return counter.n, fmt.Errorf("object %v: invalid sync marker", object.Key)
+		}
+		if _, err := e.w.Write(e.sync); err != nil {
+			return counter.n, err
+		}
+	}
+}
+
+func (e *avroEncoder) close() error {
+	if e.sync == nil {
+		return errors.New("no avro objects to merge")
+	}
+	return nil
+}
+
+// Read the header of an Avro object container file
+func readAvroHeader(r *bufio.Reader) (avroHeader, error) {
+	magic := make([]byte, len(AvroMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, AvroMagic) {
+		return avroHeader{}, errors.New("not an avro object container file")
+	}
+	header := avroHeader{metadata: map[string][]byte{}, sync: make([]byte, 16)}
+	for {
+		count, err := readAvroLong(r)
+		if err != nil {
+			return header, err
+		}
+		if count == 0 {
+			break
+		}
+		if count < 0 {
+			// A negative count is followed by the size of the block
+			count = -count
+			if _, err := readAvroLong(r); err != nil {
+				return header, err
+			}
+		}
+		for ; count > 0; count-- {
+			key, err := readAvroBytes(r)
+			if err != nil {
+				return header, err
+			}
+			value, err := readAvroBytes(r)
+			if err != nil {
+				return header, err
+			}
+			header.metadata[string(key)] = value
+		}
+	}
+	_, err := io.ReadFull(r, header.sync)
+	return header, err
+}
+
+// Write the header of an Avro object container file
+func writeAvroHeader(w io.Writer, header avroHeader) error {
+	keys := make([]string, 0, len(header.metadata))
+	for key := range header.metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b := append([]byte(nil), AvroMagic...)
+	b = binary.AppendVarint(b, int64(len(keys)))
+	for _, key := range keys {
+		b = binary.AppendVarint(b, int64(len(key)))
+		b = append(b, key...)
+		b = binary.AppendVarint(b, int64(len(header.metadata[key])))
+		b = append(b, header.metadata[key]...)
+	}
+	b = binary.AppendVarint(b, 0)
+	b = append(b, header.sync...)
+	_, err := w.Write(b)
+	return err
+}
+
+// Read a zig-zag encoded Avro long
+func readAvroLong(r *bufio.Reader) (int64, error) {
+	v, err := binary.ReadVarint(r)
+	if err == io.ErrUnexpectedEOF {
+		return v, errors.New("truncated avro object container file")
+	}
+	return v, err
+}
+
+// Read Avro bytes, a long length followed by that many bytes
+func readAvroBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := readAvroLong(r)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, errors.New("invalid avro length")
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// Return the SHA-256 fingerprint of the Parsing Canonical Form of an Avro schema
+func avroFingerprint(schema []byte) ([sha256.Size]byte, error) {
+	var s interface{}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	var b strings.Builder
+	canonicalAvroSchema(&b, s, "")
+	return sha256.Sum256([]byte(b.String())), nil
+}
+
+// Attributes kept by the Parsing Canonical Form, in their canonical order
+var avroCanonicalAttributes = []string{"name", "type", "fields", "symbols", "items", "values", "size"}
+
+// Write the Parsing Canonical Form of the Avro schema s within namespace to b
+func canonicalAvroSchema(b *strings.Builder, s interface{}, namespace string) {
+	switch s := s.(type) {
+	case string:
+		// Primitive types, or references to named types by their full name
+		if !isAvroPrimitive(s) {
+			s = avroFullName(s, namespace)
+		}
+		writeJSON(b, s)
+	case []interface{}:
+		b.WriteString("[")
+		for i, t := range s {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			canonicalAvroSchema(b, t, namespace)
+		}
+		b.WriteString("]")
+	case map[string]interface{}:
+		t, _ := s["type"].(string)
+		if isAvroPrimitive(t) && t != "" {
+			writeJSON(b, t)
+			return
+		}
+		if name, ok := s["name"].(string); ok && (t == "record" || t == "error" || t == "enum" || t == "fixed") {
+			if ns, ok := s["namespace"].(string); ok {
+				namespace = ns
+			}
+			s["name"] = avroFullName(name, namespace)
+			if i := strings.LastIndex(s["name"].(string), "."); i >= 0 {
+				namespace = s["name"].(string)[:i]
+			}
+		}
+		b.WriteString("{")
+		first := true
+		for _, attribute := range avroCanonicalAttributes {
+			v, ok := s[attribute]
+			if !ok {
+				continue
+			}
+			if !first {
+				b.WriteString(",")
+			}
+			first = false
+			writeJSON(b, attribute)
+			b.WriteString(":")
+			switch attribute {
+			case "name", "symbols", "size":
+				writeJSON(b, v)
+			case "type":
+				if t, ok := v.(string); ok && (t == "record" || t == "error" || t == "enum" || t == "array" || t == "map" || t == "fixed") {
+					writeJSON(b, t)
+				} else {
+					canonicalAvroSchema(b, v, namespace)
+				}
+			case "fields":
+				fields, _ := v.([]interface{})
+				b.WriteString("[")
+				for i, field := range fields {
+					if i > 0 {
+						b.WriteString(",")
+					}
+					f, _ := field.(map[string]interface{})
+					b.WriteString(`{"name":`)
+					writeJSON(b, f["name"])
+					b.WriteString(`,"type":`)
+					canonicalAvroSchema(b, f["type"], namespace)
+					b.WriteString("}")
+				}
+				b.WriteString("]")
+			default:
+				canonicalAvroSchema(b, v, namespace)
+			}
+		}
+		b.WriteString("}")
+	}
+}
+
+// Return whether t is an Avro primitive type
+func isAvroPrimitive(t string) bool {
+	switch t {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return true
+	}
+	return false
+}
+
+// Return the full name of an Avro named type within namespace
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// Write the JSON encoding of v to b
+func writeJSON(b *strings.Builder, v interface{}) {
+	data, _ := json.Marshal(v)
+	b.Write(data)
+}
@@ -0,0 +1,584 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AzureAPIVersion is the version of the Azure Blob Storage REST API used
+	AzureAPIVersion = "2020-10-02"
+	// MaxAzureBlockCount is the maximum number of blocks in a block blob
+	MaxAzureBlockCount = 50000
+)
+
+// azureSink uploads the resulting object to an Azure Blob Storage container, as a block blob of part-size blocks.
+// Requests are authorized with the account key in AZURE_STORAGE_KEY, or the SAS token in AZURE_STORAGE_SAS_TOKEN.
+type azureSink struct {
+	client   *http.Client
+	account  string
+	key      []byte
+	sasToken string
+	endpoint string
+}
+
+// Create the Azure Blob Storage sink of the account in AZURE_STORAGE_ACCOUNT
+func newAzureSink() (*azureSink, error) {
+	tr, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+	s := &azureSink{
+		client:   &http.Client{Transport: tr},
+		account:  os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		sasToken: strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+	}
+	if s.account == "" {
+		return nil, errors.New("AZURE_STORAGE_ACCOUNT must be set for an azure target")
+	}
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		s.key, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("AZURE_STORAGE_KEY is invalid: %v", err)
+		}
+	} else if s.sasToken == "" {
+		return nil, errors.New("AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN must be set for an azure target")
+	}
+	s.endpoint = "https://" + s.account + ".blob.core.windows.net"
+	return s, nil
+}
+
+func (s *azureSink) upload(ctx context.Context, r io.Reader) error {
+	err := s.makeContainer(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Upload the object
+	log.Printf("Uploading %s to %s\n", targetObjectName, targetBucketPrefix)
+	err = s.uploadBlocks(ctx, r)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to upload object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Successfully uploaded %s to %s", targetObjectName, targetBucketPrefix), "object", targetObjectName)
+	return nil
+}
+
+// Upload the contents of r as blocks of part-size, with up to upload-concurrency blocks in flight, then commit them
+func (s *azureSink) uploadBlocks(ctx context.Context, r io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		blockIDs []string
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	// Buffers are recycled between blocks, bounding memory and concurrency together, allocated as blocks are read
+	buffers := make(chan []byte, uploadConcurrency)
+	for i := 0; i < int(uploadConcurrency); i++ {
+		buffers <- nil
+	}
+
+	for blockNumber := 0; ; blockNumber++ {
+		buf, ok := nextBuffer(ctx, buffers, int(partSize))
+		if !ok {
+			break
+		}
+		n, rerr := io.ReadFull(r, buf)
+		if rerr == io.EOF {
+			buffers <- buf
+			break
+		}
+		if rerr != nil && rerr != io.ErrUnexpectedEOF {
+			fail(rerr)
+			break
+		}
+		if blockNumber >= MaxAzureBlockCount {
+			fail(fmt.Errorf("resulting object exceeds %v blocks of %v bytes", MaxAzureBlockCount, partSize))
+			break
+		}
+
+		// Block IDs must all have the same length
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", blockNumber)))
+		blockIDs = append(blockIDs, blockID)
+		wg.Add(1)
+		go func(blockID string, buf []byte, n int) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+			query := url.Values{"comp": {"block"}, "blockid": {blockID}}
+			err := s.do(ctx, http.MethodPut, s.blobPath(targetObjectName), query, nil, buf[:n], http.StatusCreated)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to upload block %v of %v - %v", blockID, targetObjectName, err), "block", blockID, "object", targetObjectName, "error", err.Error())
+				fail(err)
+			}
+		}(blockID, buf, n)
+
+		if rerr != nil {
+			// Short read, this was the last block
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		// Uncommitted blocks are garbage collected by the service
+		return firstErr
+	}
+
+	// Commit the blocks, in order, as the blob
+	type blockList struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}
+	body, err := xml.Marshal(blockList{Latest: blockIDs})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"X-Ms-Blob-Content-Type": {contentType()}}
+	if encoding := contentEncoding(); encoding != "" {
+		header.Set("X-Ms-Blob-Content-Encoding", encoding)
+	}
+	if ifNoneMatch == FailIfExists {
+		// Fail the commit should the blob have been created since its name was claimed
+		header.Set("If-None-Match", "*")
+	}
+	setBlobMetadata(header)
+	if len(targetTags) > 0 {
+		header.Set("X-Ms-Tags", blobTags())
+	}
+	if storageClass != "" {
+		header.Set("X-Ms-Access-Tier", storageClass)
+	}
+	return s.do(ctx, http.MethodPut, s.blobPath(targetObjectName), url.Values{"comp": {"blocklist"}}, header, body, http.StatusCreated)
+}
+
+// Set the metadata of the resulting object as blob metadata headers
+func setBlobMetadata(header http.Header) {
+	for name, value := range targetMetadata() {
+		// Metadata names must be C# identifiers
+		header.Set("X-Ms-Meta-"+strings.ReplaceAll(name, "-", "_"), value)
+	}
+}
+
+func (s *azureSink) rename(ctx context.Context, from, to string) error {
+	// Copies within the account are authorized by the request, and usually complete synchronously
+	source := s.endpoint + (&url.URL{Path: s.blobPath(from)}).EscapedPath()
+	if s.key == nil {
+		source += "?" + s.sasToken
+	}
+	header := http.Header{"X-Ms-Copy-Source": {source}}
+	// The metadata of the copy replaces that of the source, computed before its checksums were
+	setBlobMetadata(header)
+	if len(targetTags) > 0 {
+		// Tags are not copied
+		header.Set("X-Ms-Tags", blobTags())
+	}
+	if storageClass != "" {
+		header.Set("X-Ms-Access-Tier", storageClass)
+	}
+	if ifNoneMatch != "" {
+		// Fail the copy should the blob have been created since its name was claimed
+		header.Set("If-None-Match", "*")
+	}
+	req, err := s.newRequest(ctx, http.MethodPut, s.blobPath(to), nil, header, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if ifNoneMatch != "" && (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusPreconditionFailed) {
+		return errTargetExists
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return newAzureError(resp)
+	}
+	status := resp.Header.Get("X-Ms-Copy-Status")
+	for status == "pending" {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if status, err = s.copyStatus(ctx, to); err != nil {
+			return err
+		}
+	}
+	if status != "success" {
+		return fmt.Errorf("azure: copy %v", status)
+	}
+	return s.do(ctx, http.MethodDelete, s.blobPath(from), nil, nil, nil, http.StatusAccepted)
+}
+
+func (s *azureSink) exists(ctx context.Context, name string) (bool, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, s.blobPath(name), nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, &azureError{status: resp.StatusCode}
+}
+
+// Return the target-tag tags as the blob index tags header value
+func blobTags() string {
+	values := url.Values{}
+	for key, value := range targetTags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// Return the status of the copy to the blob name
+func (s *azureSink) copyStatus(ctx context.Context, name string) (string, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, s.blobPath(name), nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &azureError{status: resp.StatusCode}
+	}
+	return resp.Header.Get("X-Ms-Copy-Status"), nil
+}
+
+// Make the target container if it does not exist
+func (s *azureSink) makeContainer(ctx context.Context) error {
+	err := s.do(ctx, http.MethodPut, "/"+targetBucket, url.Values{"restype": {"container"}}, nil, nil, http.StatusCreated)
+	var statusErr *azureError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusConflict {
+		log.Printf("Container already exists: %s\n", targetBucket)
+		return nil
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to create container: %s - %v", targetBucket, err), "bucket", targetBucket, "error", err.Error())
+		return err
+	}
+	log.Printf("Successfully created container %s\n", targetBucket)
+	return nil
+}
+
+func (s *azureSink) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, s.blobPath(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newAzureError(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *azureSink) list(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		type blobList struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					LastModified  string `xml:"Last-Modified"`
+					ContentLength int64  `xml:"Content-Length"`
+				} `xml:"Properties"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		marker := ""
+		for {
+			query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {strings.TrimPrefix(prefix, "/")}}
+			if marker != "" {
+				query.Set("marker", marker)
+			}
+			var list blobList
+			err := s.getXML(ctx, "/"+targetBucket, query, &list)
+			if err != nil {
+				objects <- minio.ObjectInfo{Key: prefix, Err: err}
+				return
+			}
+			for _, blob := range list.Blobs {
+				modified, _ := time.Parse(http.TimeFormat, blob.Properties.LastModified)
+				select {
+				case objects <- minio.ObjectInfo{Key: blob.Name, Size: blob.Properties.ContentLength, LastModified: modified}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if marker = list.NextMarker; marker == "" {
+				return
+			}
+		}
+	}()
+	return objects
+}
+
+// Perform a GET request, decoding the XML response into v
+func (s *azureSink) getXML(ctx context.Context, path string, query url.Values, v any) error {
+	req, err := s.newRequest(ctx, http.MethodGet, path, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newAzureError(resp)
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *azureSink) getState(ctx context.Context, name string) ([]byte, error) {
+	data, _, err := s.getStateVersion(ctx, name)
+	return data, err
+}
+
+// The version of a state blob is its ETag
+func (s *azureSink) getStateVersion(ctx context.Context, name string) ([]byte, string, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, s.blobPath(name), nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", newAzureError(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func (s *azureSink) putState(ctx context.Context, name string, data []byte) error {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}, "Content-Type": {"application/json"}}
+	return s.do(ctx, http.MethodPut, s.blobPath(name), nil, header, data, http.StatusCreated)
+}
+
+func (s *azureSink) createState(ctx context.Context, name string, data []byte) (bool, error) {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}, "Content-Type": {"application/json"}, "If-None-Match": {"*"}}
+	err := s.do(ctx, http.MethodPut, s.blobPath(name), nil, header, data, http.StatusCreated)
+	var statusErr *azureError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusConflict {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *azureSink) replaceState(ctx context.Context, name string, data []byte, version string) (bool, error) {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}, "Content-Type": {"application/json"}, "If-Match": {version}}
+	err := s.do(ctx, http.MethodPut, s.blobPath(name), nil, header, data, http.StatusCreated)
+	var statusErr *azureError
+	if errors.As(err, &statusErr) && (statusErr.status == http.StatusPreconditionFailed || statusErr.status == http.StatusNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *azureSink) removeState(ctx context.Context, name string) error {
+	return s.do(ctx, http.MethodDelete, s.blobPath(name), nil, nil, nil, http.StatusAccepted)
+}
+
+// Return the path of the blob name in the target container
+func (s *azureSink) blobPath(name string) string {
+	return "/" + targetBucket + "/" + strings.TrimPrefix(name, "/")
+}
+
+// Perform a request, expecting the status code want
+func (s *azureSink) do(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte, want int) error {
+	req, err := s.newRequest(ctx, method, path, query, header, body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		return newAzureError(resp)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// Create an authorized request
+func (s *azureSink) newRequest(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte) (*http.Request, error) {
+	u, err := url.Parse(s.endpoint + (&url.URL{Path: path}).EscapedPath())
+	if err != nil {
+		return nil, err
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	u.RawQuery = query.Encode()
+	if s.key == nil {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += s.sasToken
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", AzureAPIVersion)
+	if s.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+s.account+":"+s.sign(req, path, query))
+	}
+	return req, nil
+}
+
+// Return the Shared Key signature of the request
+func (s *azureSink) sign(req *http.Request, path string, query url.Values) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	h := req.Header
+	stringToSign := strings.Join([]string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		length,
+		h.Get("Content-Md5"),
+		h.Get("Content-Type"),
+		"", // Date, superseded by x-ms-date
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	}, "\n") + "\n"
+
+	// Canonicalized headers
+	var names []string
+	for name := range h {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stringToSign += name + ":" + strings.TrimSpace(h.Get(name)) + "\n"
+	}
+
+	// Canonicalized resource
+	stringToSign += "/" + s.account + (&url.URL{Path: path}).EscapedPath()
+	var params []string
+	for param := range query {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		values := append([]string(nil), query[param]...)
+		sort.Strings(values)
+		stringToSign += "\n" + strings.ToLower(param) + ":" + strings.Join(values, ",")
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// azureError is an unexpected response of the Azure Blob Storage service
+type azureError struct {
+	status int
+	code   string
+}
+
+func newAzureError(resp *http.Response) *azureError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e struct {
+		Code string `xml:"Code"`
+	}
+	_ = xml.Unmarshal(body, &e)
+	return &azureError{status: resp.StatusCode, code: e.Code}
+}
+
+func (e *azureError) Error() string {
+	return fmt.Sprintf("azure: %v %v", e.status, e.code)
+}
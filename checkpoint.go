@@ -0,0 +1,165 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckpointName is the name of the state object recording the progress of an interrupted run
+const CheckpointName = "checkpoint.json"
+
+// checkpoint records how far a run got, so that an interrupted run can be resumed
+type checkpoint struct {
+	// TargetObjectName is the name of the resulting object being uploaded
+	TargetObjectName string `json:"targetObjectName"`
+	// UploadID identifies the multipart upload of the resulting object
+	UploadID string `json:"uploadId"`
+	// PartSize is the size of every part of the multipart upload
+	PartSize uint64 `json:"partSize"`
+	// Parts are the contiguous parts uploaded so far
+	Parts []minio.CompletePart `json:"parts"`
+	// Key is the source object in which the next part starts
+	Key string `json:"key"`
+	// Offset is the offset within Key at which the next part starts
+	Offset int64 `json:"offset"`
+	// LastModified is the highest LastModified of the source objects appended so far
+	LastModified time.Time `json:"lastModified"`
+	// LastModifiedKey is the highest key of the source objects appended so far modified at LastModified
+	LastModifiedKey string `json:"lastModifiedKey,omitempty"`
+}
+
+// The checkpoint being resumed, if any
+var resumeFrom *checkpoint
+
+// The number of parts in the checkpoint saved by the current run when its upload failed, if any
+var savedParts int
+
+// position is the offset of a source object within the resulting object
+type position struct {
+	key   string
+	start int64
+}
+
+// Positions of the source objects appended so far, used to locate part boundaries, along with the highest
+// LastModified of the source objects appended so far, the highest key modified at it and the key of the first one
+var positions struct {
+	sync.Mutex
+	list      []position
+	latest    time.Time
+	latestKey string
+	first     string
+}
+
+// Return the name of a state object kept alongside the target for this source bucket/prefix
+func stateObjectName(name string) string {
+	// Each worker progresses through its share of the source objects on its own
+	return path.Join(targetPrefix, ".object-appender", sourceBucket, strings.Trim(sourcePrefix, "/"), workerSuffix(), name)
+}
+
+// Record that the source object starts at offset start within the resulting object
+func beginObject(object minio.ObjectInfo, start int64) {
+	recordMatch(object.Key)
+	recordAppended(object)
+	positions.Lock()
+	positions.list = append(positions.list, position{key: object.Key, start: start})
+	if positions.first == "" {
+		positions.first = object.Key
+	}
+	if laterModified(object.LastModified, object.Key, positions.latest, positions.latestKey) {
+		positions.latest, positions.latestKey = object.LastModified, object.Key
+	}
+	positions.Unlock()
+}
+
+// Return the key of the first source object appended
+func firstObject() string {
+	positions.Lock()
+	defer positions.Unlock()
+	return positions.first
+}
+
+// Return the key of the last source object appended
+func lastObject() string {
+	positions.Lock()
+	defer positions.Unlock()
+	if len(positions.list) == 0 {
+		return ""
+	}
+	return positions.list[len(positions.list)-1].key
+}
+
+// Return the highest LastModified of the source objects appended so far, and the highest key modified at it
+func latestModified() (time.Time, string) {
+	positions.Lock()
+	defer positions.Unlock()
+	return positions.latest, positions.latestKey
+}
+
+// Return the source object and the offset within it at the given offset of the resulting object,
+// forgetting the positions of source objects entirely before it
+func locate(offset int64) (string, int64) {
+	positions.Lock()
+	defer positions.Unlock()
+	i := sort.Search(len(positions.list), func(i int) bool { return positions.list[i].start > offset }) - 1
+	if i < 0 {
+		return "", 0
+	}
+	p := positions.list[i]
+	positions.list = positions.list[i:]
+	return p.key, offset - p.start
+}
+
+// Load the checkpoint of an interrupted run, returning nil if there is none
+func loadCheckpoint(ctx context.Context, target sink) (*checkpoint, error) {
+	data, err := target.getState(ctx, stateObjectName(CheckpointName))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	c := new(checkpoint)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Save the checkpoint of the current run
+func saveCheckpoint(ctx context.Context, target sink, c *checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return target.putState(ctx, stateObjectName(CheckpointName), data)
+}
+
+// Remove the checkpoint once the run has completed
+func removeCheckpoint(ctx context.Context, target sink) {
+	err := target.removeState(ctx, stateObjectName(CheckpointName))
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to remove checkpoint %v - %v", stateObjectName(CheckpointName), err), "object", stateObjectName(CheckpointName), "error", err.Error())
+	}
+}
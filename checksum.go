@@ -0,0 +1,74 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"hash/crc32"
+	"maps"
+	"net/http"
+	"strings"
+)
+
+// checksum-algorithm values, selecting the checksum the server validates each uploaded part against
+const (
+	CRC32CChecksum = "CRC32C"
+	SHA256Checksum = "SHA256"
+)
+
+// Check the checksum-algorithm, normalizing its case
+func parseChecksumAlgorithm() error {
+	checksumAlgorithm = strings.ToUpper(checksumAlgorithm)
+	if checksumAlgorithm != "" && checksumAlgorithm != CRC32CChecksum && checksumAlgorithm != SHA256Checksum {
+		return errors.New("checksum-algorithm must be CRC32C or SHA256")
+	}
+	return nil
+}
+
+// Return the options creating a multipart upload whose parts are uploaded with a checksum-algorithm checksum
+func checksumUploadOptions(opts minio.PutObjectOptions) minio.PutObjectOptions {
+	if checksumAlgorithm == "" {
+		return opts
+	}
+	opts.UserMetadata = maps.Clone(opts.UserMetadata)
+	if opts.UserMetadata == nil {
+		opts.UserMetadata = map[string]string{}
+	}
+	opts.UserMetadata["X-Amz-Checksum-Algorithm"] = checksumAlgorithm
+	return opts
+}
+
+// Return the checksum-algorithm checksum of a part of contents data, as the header uploading the part and as the
+// checksum of the part completing the upload, or a nil header if there is no checksum-algorithm
+func partChecksum(data []byte) (http.Header, minio.CompletePart) {
+	switch checksumAlgorithm {
+	case CRC32CChecksum:
+		sum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		sum.Write(data)
+		value := base64.StdEncoding.EncodeToString(sum.Sum(nil))
+		return http.Header{"X-Amz-Checksum-Crc32c": {value}}, minio.CompletePart{ChecksumCRC32C: value}
+	case SHA256Checksum:
+		sum := sha256.Sum256(data)
+		value := base64.StdEncoding.EncodeToString(sum[:])
+		return http.Header{"X-Amz-Checksum-Sha256": {value}}, minio.CompletePart{ChecksumSHA256: value}
+	}
+	return nil, minio.CompletePart{}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/minio/minio-go/v7"
+	"net/http"
+	"testing"
+)
+
+func TestPartChecksumsSentAndCompleted(t *testing.T) {
+	defer func(algorithm string) { checksumAlgorithm = algorithm }(checksumAlgorithm)
+	data := bytes.Repeat([]byte("part contents\n"), 10000)
+	for _, tt := range []struct {
+		algorithm, header, value string
+	}{
+		{CRC32CChecksum, "X-Amz-Checksum-Crc32c", "NTLY2Q=="},
+		{SHA256Checksum, "X-Amz-Checksum-Sha256", "pj1nekgCJiFO9s60E8yX0iQgRTFsPmTDxtzMZpXog9A="},
+	} {
+		checksumAlgorithm = tt.algorithm
+		var sent string
+		var completed []byte
+		core := &minio.Core{Client: signingClient(t, func(w http.ResponseWriter, r *http.Request, payload []byte) {
+			switch r.Method {
+			case http.MethodPut:
+				sent = r.Header.Get(tt.header)
+				if !bytes.Equal(payload, data) {
+					t.Errorf("%v: part of %v bytes received, want %v", tt.algorithm, len(payload), len(data))
+				}
+			case http.MethodPost:
+				completed = payload
+				w.Write([]byte(`<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>object</Key><ETag>"d41d8cd98f00b204e9800998ecf8427e-1"</ETag></CompleteMultipartUploadResult>`))
+			}
+		})}
+
+		header, checksum := partChecksum(data)
+		ctx := withPartHeaders(context.Background(), header, data)
+		part, err := core.PutObjectPart(ctx, "bucket", "object", "upload", 1, bytes.NewReader(data), int64(len(data)), "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != tt.value {
+			t.Errorf("%v: sent %v %q, want %q", tt.algorithm, tt.header, sent, tt.value)
+		}
+		checksum.PartNumber, checksum.ETag = part.PartNumber, part.ETag
+		if _, err := core.CompleteMultipartUpload(context.Background(), "bucket", "object", "upload", []minio.CompletePart{checksum}, minio.PutObjectOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(completed, []byte("<Checksum"+tt.algorithm+">"+tt.value+"</Checksum"+tt.algorithm+">")) {
+			t.Errorf("%v: completed with %s, want the checksum of the part", tt.algorithm, completed)
+		}
+
+		// Resumed uploads complete with the checksums saved in the checkpoint
+		saved, err := json.Marshal(checkpoint{Parts: []minio.CompletePart{checksum}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resumed checkpoint
+		if err := json.Unmarshal(saved, &resumed); err != nil {
+			t.Fatal(err)
+		}
+		if resumed.Parts[0] != checksum {
+			t.Errorf("%v: checkpoint part %+v, want %+v", tt.algorithm, resumed.Parts[0], checksum)
+		}
+	}
+}
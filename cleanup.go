@@ -0,0 +1,224 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"log"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// cleanUp deletes the source objects once appended to a complete resulting object, with enable-clean-up
+	cleanUp bool
+	// cleanUpClient is the client of the source bucket the source objects are deleted from
+	cleanUpClient *minio.Client
+	errCleanUp    = errors.New("failed to remove source objects")
+)
+
+// appended are the source objects appended to the resulting object, deleted once it is complete
+var appended struct {
+	sync.Mutex
+	objects []minio.ObjectInfo
+}
+
+// Parse enable-clean-up and trash-prefix
+func parseCleanUp() error {
+	var err error
+	if cleanUp, err = strconv.ParseBool(enableCleanUp); err != nil {
+		return errors.New("enable-clean-up must be true or false")
+	}
+	if trashPrefix == "" {
+		return nil
+	}
+	if !cleanUp {
+		return errors.New("trash-prefix requires enable-clean-up")
+	}
+	if trashTTL <= 0 {
+		return errors.New("trash-ttl must be positive")
+	}
+	trashPrefix = strings.Trim(trashPrefix, "/")
+	return nil
+}
+
+// Record that the source object is appended to the resulting object
+func recordAppended(object minio.ObjectInfo) {
+	if !cleanUp {
+		return
+	}
+	appended.Lock()
+	appended.objects = append(appended.objects, object)
+	appended.Unlock()
+}
+
+// Forget the source objects appended to the resulting object
+func resetAppended() {
+	appended.Lock()
+	appended.objects = nil
+	appended.Unlock()
+}
+
+// Return the key of the source object key moved to the trash-prefix
+func trashKey(key string) string {
+	return path.Join(trashPrefix, key)
+}
+
+// Copy the source object into the trash-prefix before it is deleted
+func trashObject(ctx context.Context, object minio.ObjectInfo) error {
+	dst := minio.CopyDestOptions{Bucket: sourceBucket, Object: trashKey(object.Key), Encryption: sourceEncryption}
+	src := minio.CopySrcOptions{Bucket: sourceBucket, Object: object.Key, MatchETag: object.ETag, Encryption: sourceEncryption}
+	_, err := cleanUpClient.ComposeObject(ctx, dst, src)
+	return err
+}
+
+// Delete the objects of the trash-prefix moved there more than trash-ttl ago
+func purgeTrash(ctx context.Context) {
+	expired := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(expired)
+		opts := minio.ListObjectsOptions{Prefix: strings.TrimSuffix(trashPrefix, "/") + "/", Recursive: true}
+		for object := range cleanUpClient.ListObjects(ctx, sourceBucket, opts) {
+			if object.Err != nil {
+				slog.Warn(fmt.Sprintf("Failed to list: %v - %v", trashPrefix, object.Err), "prefix", trashPrefix, "error", object.Err.Error())
+				return
+			}
+			if time.Since(object.LastModified) < trashTTL {
+				continue
+			}
+			select {
+			case expired <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for result := range cleanUpClient.RemoveObjects(ctx, sourceBucket, expired, minio.RemoveObjectsOptions{}) {
+		slog.Warn(fmt.Sprintf("Failed to remove object %v - %v", result.ObjectName, result.Err), "key", result.ObjectName, "error", result.Err.Error())
+	}
+}
+
+// Check that the resulting object put in place is complete before the source objects appended to it are deleted:
+// its size, and its SHA-256 read back with output-checksum. The append-to object was verified when staged.
+func verifyCleanUp(ctx context.Context, target sink) error {
+	if appendTo != "" {
+		if !stagedVerified {
+			return fmt.Errorf("object %v was not verified when staged", targetObjectName)
+		}
+		return nil
+	}
+	if err := verifySize(ctx, target, targetObjectName); err != nil {
+		return err
+	}
+	if !outputChecksum {
+		return nil
+	}
+	if contentHash == "" {
+		return fmt.Errorf("object %v has no checksum", targetObjectName)
+	}
+	r, err := target.open(ctx, targetObjectName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != contentHash {
+		return fmt.Errorf("object %v has SHA-256 %v, uploaded %v", targetObjectName, sum, contentHash)
+	}
+	return nil
+}
+
+// Delete the source objects appended to the complete resulting object with enable-clean-up, in batches,
+// logging each object that fails to be removed, once the resulting object is verified. With trash-prefix, each
+// object is first copied into it, and objects in it older than trash-ttl are deleted.
+func cleanUpSources(ctx context.Context, target sink) error {
+	if !cleanUp {
+		return nil
+	}
+	appended.Lock()
+	objects := appended.objects
+	appended.objects = nil
+	appended.Unlock()
+	if len(objects) == 0 {
+		return nil
+	}
+	if err := verifyCleanUp(ctx, target); err != nil {
+		slog.Error(fmt.Sprintf("Failed to verify object %v, keeping %v source objects - %v", targetObjectName, len(objects), err), "object", targetObjectName, "objects", len(objects), "error", err.Error())
+		return errCleanUp
+	}
+
+	if trashPrefix != "" {
+		log.Printf("Moving %v source objects appended to %s to %s\n", len(objects), targetObjectName, trashPrefix)
+	} else {
+		log.Printf("Removing %v source objects appended to %s\n", len(objects), targetObjectName)
+	}
+	var changed int
+	queue := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(queue)
+		for _, object := range objects {
+			// An object overwritten since it was appended is kept
+			info, err := cleanUpClient.StatObject(ctx, sourceBucket, object.Key, minio.StatObjectOptions{ServerSideEncryption: sourceEncryption})
+			if err == nil && object.ETag != "" && info.ETag != object.ETag {
+				err = errors.New("changed since appended")
+			}
+			if err == nil && trashPrefix != "" {
+				err = trashObject(ctx, object)
+			}
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to remove object %v - %v", object.Key, err), "key", object.Key, "error", err.Error())
+				changed++
+				continue
+			}
+			select {
+			case queue <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	failed := 0
+	for result := range cleanUpClient.RemoveObjects(ctx, sourceBucket, queue, minio.RemoveObjectsOptions{}) {
+		slog.Error(fmt.Sprintf("Failed to remove object %v - %v", result.ObjectName, result.Err), "key", result.ObjectName, "error", result.Err.Error())
+		failed++
+	}
+	// The queue is closed once the results are
+	failed += changed
+	if trashPrefix != "" {
+		purgeTrash(ctx)
+	}
+	if failed > 0 {
+		slog.Error(fmt.Sprintf("Failed to remove source objects: %v of %v", failed, len(objects)), "failed", failed, "objects", len(objects))
+		return errCleanUp
+	}
+	log.Printf("Successfully removed %v source objects\n", len(objects))
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"log"
+	"log/slog"
+)
+
+const (
+	// MaxComposeSources is the maximum number of source objects a single compose may reference
+	MaxComposeSources = 10000
+	// MinComposePartSize is the minimum size of every compose source except the last
+	MinComposePartSize = 1024 * 1024 * 5
+	// MaxComposeSize is the maximum size of the resulting composed object
+	MaxComposeSize = 1024 * 1024 * 1024 * 1024 * 5
+)
+
+// errComposeLimits is returned when the source objects cannot be composed server-side
+var errComposeLimits = errors.New("source objects exceed compose limits")
+
+// Build the single resulting object on the server from the source objects, without any data transiting the client.
+// Returns errComposeLimits, before anything is written, if the sources cannot be composed.
+// The source objects must be read from an s3 bucket on the same endpoint as the target.
+func composeObjects(ctx context.Context, src source, targetClient *minio.Client) error {
+	var objects []minio.ObjectInfo
+	var size int64
+	for object := range listSourceObjects(ctx, src, "", true) {
+		if object.Err != nil {
+			slog.Error(fmt.Sprintf("Failed to list: %v - %v", object.Key, object.Err), "key", object.Key, "error", object.Err.Error())
+			return object.Err
+		}
+		objects = append(objects, object)
+		size += object.Size
+	}
+	if len(objects) == 0 {
+		slog.Error("Failed to find objects - exiting")
+		return errNoObjects
+	}
+	slog.Info(fmt.Sprintf("Found objects: %v, size: %v", len(objects), size), "objects", len(objects), "bytes", size)
+
+	// Check compose limits
+	if len(objects) > MaxComposeSources {
+		slog.Warn(fmt.Sprintf("Unable to compose more than %v objects", MaxComposeSources), "objects", MaxComposeSources)
+		return errComposeLimits
+	}
+	if size > MaxComposeSize {
+		slog.Warn(fmt.Sprintf("Unable to compose more than %v bytes", int64(MaxComposeSize)), "bytes", int64(MaxComposeSize))
+		return errComposeLimits
+	}
+	for _, object := range objects[:len(objects)-1] {
+		if object.Size < MinComposePartSize {
+			slog.Warn(fmt.Sprintf("Unable to compose object smaller than %v bytes: %v", MinComposePartSize, object.Key), "key", object.Key, "bytes", object.Size)
+			return errComposeLimits
+		}
+	}
+
+	if format == GzipMembersFormat {
+		for _, object := range objects {
+			if err := checkComposedGzipMember(ctx, src, object.Key); err != nil {
+				slog.Error(fmt.Sprintf("Failed to compose object: %v - %v", object.Key, err), "key", object.Key, "error", err.Error())
+				return err
+			}
+		}
+	}
+
+	if preserveMetadataMode != "" {
+		for i, object := range objects {
+			if preserveMetadataMode == FirstMetadata && i > 0 {
+				break
+			}
+			metadata, err := objectMetadata(ctx, src, object)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to obtain metadata: %v - %v", object.Key, err), "key", object.Key, "error", err.Error())
+				return err
+			}
+			preserveMetadata(metadata)
+		}
+	}
+
+	detectContentType(objects[0].Key, nil)
+
+	err := makeTargetBucket(ctx, targetClient)
+	if err != nil {
+		return err
+	}
+
+	srcs := make([]minio.CopySrcOptions, 0, len(objects))
+	var offset int64
+	for _, object := range objects {
+		addManifest(object, offset, object.Size)
+		offset += object.Size
+		srcs = append(srcs, minio.CopySrcOptions{Bucket: sourceBucket, Object: object.Key, MatchETag: object.ETag, Encryption: sourceEncryption})
+	}
+	dst := minio.CopyDestOptions{Bucket: targetBucket, Object: targetObjectName, UserMetadata: copyMetadata(), ReplaceMetadata: true, UserTags: targetTags, ReplaceTags: true, Encryption: targetEncryption}
+	retainCopy(&dst, temporaryTarget())
+
+	// Compose the object
+	log.Printf("Composing %s in %s\n", targetObjectName, targetBucketPrefix)
+	if ifNoneMatch != "" && !temporaryTarget() {
+		err = composeIfNoneMatch(ctx, targetClient, dst, srcs...)
+	} else {
+		_, err = targetClient.ComposeObject(ctx, dst, srcs...)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to compose object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return err
+	}
+	objectCount, objectSize = int64(len(objects)), size
+	for _, object := range objects {
+		beginObject(object, 0)
+	}
+
+	slog.Info(fmt.Sprintf("Successfully composed %s in %s", targetObjectName, targetBucketPrefix), "object", targetObjectName)
+	return nil
+}
+
+// Check that the source object is a gzip member, reading only its first bytes
+func checkComposedGzipMember(ctx context.Context, src source, key string) error {
+	r, err := src.open(ctx, key, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	head := make([]byte, len(gzipMagic))
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	return checkGzipMember(key, head[:n])
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"io"
+)
+
+// DefaultCompressLevel selects the default level of each compression
+const DefaultCompressLevel = -1
+
+// compression is the extension and content encoding of the resulting object compressed with a codec.
+// Codecs without a registered HTTP content encoding leave it empty.
+type compression struct {
+	extension, contentEncoding string
+}
+
+// Compressions maps the compress values to the compressions of the resulting object
+var Compressions = map[string]compression{
+	"gzip":   {".gz", "gzip"},
+	"zstd":   {".zst", "zstd"},
+	"lz4":    {".lz4", ""},
+	"snappy": {".sz", ""},
+}
+
+// Return the writer compressing the resulting object written to w
+func newCompressor(w io.Writer) (io.WriteCloser, error) {
+	switch compress {
+	case "gzip":
+		return gzip.NewWriterLevel(w, compressLevel)
+	case "zstd":
+		level := zstd.SpeedDefault
+		if compressLevel != DefaultCompressLevel {
+			if compressLevel < 1 || compressLevel > 22 {
+				return nil, fmt.Errorf("zstd: invalid compression level: %v", compressLevel)
+			}
+			level = zstd.EncoderLevelFromZstd(compressLevel)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case "lz4":
+		z := lz4.NewWriter(w)
+		if compressLevel != DefaultCompressLevel {
+			if compressLevel < 1 || compressLevel > 9 {
+				return nil, fmt.Errorf("lz4: invalid compression level: %v", compressLevel)
+			}
+			if err := z.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (8 + compressLevel)))); err != nil {
+				return nil, err
+			}
+		}
+		return z, nil
+	case "snappy":
+		if compressLevel != DefaultCompressLevel {
+			return nil, errors.New("snappy: compression levels are not supported")
+		}
+		return snappy.NewBufferedWriter(w), nil
+	}
+	return nil, fmt.Errorf("compress %v is invalid", compress)
+}
+
+// Check the compression options
+func validateCompression() error {
+	if compress == "" {
+		return nil
+	}
+	if _, ok := Compressions[compress]; !ok {
+		return fmt.Errorf("compress %v is invalid", compress)
+	}
+	if _, err := newCompressor(io.Discard); err != nil {
+		return fmt.Errorf("compress-level %v is invalid: %v", compressLevel, err)
+	}
+	return nil
+}
+
+// Return the content encoding of the resulting object, if compressed and not encrypted client-side
+func contentEncoding() string {
+	if clientEncrypted() {
+		return ""
+	}
+	return Compressions[compress].contentEncoding
+}
+
+// compressedEncoder compresses the output of another encoder
+type compressedEncoder struct {
+	encoder
+	c io.WriteCloser
+}
+
+func (e *compressedEncoder) close() error {
+	if err := e.encoder.close(); err != nil {
+		return err
+	}
+	return e.c.Close()
+}
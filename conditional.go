@@ -0,0 +1,117 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"net/http"
+)
+
+// Write the resulting object under its final name with If-None-Match: * with if-none-match, so that the write
+// fails rather than overwrite an object created since the name was claimed
+func conditionalPut(opts *minio.PutObjectOptions, temporary bool) {
+	if ifNoneMatch != "" && !temporary {
+		ifNoneMatchAny(opts)
+	}
+}
+
+// Make the write fail if the object exists, with If-None-Match: *, sent bare by signingTransport. The payload is
+// not signed in chunks, as chunk signatures chain from the signature of the request, which is signed again.
+func ifNoneMatchAny(opts *minio.PutObjectOptions) {
+	opts.SetMatchETagExcept("*")
+	opts.DisableContentSha256 = true
+}
+
+// Return whether the conditional write failed as the object exists
+func preconditionFailed(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+// Compose the sources into dst as ComposeObject does, by a multipart copy, but completing it with If-None-Match: *
+// so that an existing object is never overwritten. It fails with errTargetExists if dst exists.
+func composeIfNoneMatch(ctx context.Context, client *minio.Client, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) error {
+	core := &minio.Core{Client: client}
+	sizes := make([]int64, len(srcs))
+	for i := range srcs {
+		info, err := client.StatObject(ctx, srcs[i].Bucket, srcs[i].Object, minio.StatObjectOptions{ServerSideEncryption: encrypt.SSE(srcs[i].Encryption)})
+		if err != nil {
+			return err
+		}
+		if i < len(srcs)-1 && info.Size < MinPartSize {
+			return fmt.Errorf("object %v is smaller than %v bytes", srcs[i].Object, MinPartSize)
+		}
+		// The sources must be unchanged until they are copied
+		srcs[i].MatchETag, sizes[i] = info.ETag, info.Size
+	}
+
+	opts := minio.PutObjectOptions{ServerSideEncryption: dst.Encryption, UserMetadata: dst.UserMetadata, UserTags: dst.UserTags,
+		Mode: dst.Mode, RetainUntilDate: dst.RetainUntilDate, LegalHold: dst.LegalHold}
+	uploadID, err := core.NewMultipartUpload(ctx, dst.Bucket, dst.Object, opts)
+	if err != nil {
+		return err
+	}
+	var parts []minio.CompletePart
+	for i, src := range srcs {
+		h := http.Header{}
+		src.Marshal(h)
+		if dst.Encryption != nil && dst.Encryption.Type() == encrypt.SSEC {
+			dst.Encryption.Marshal(h)
+		}
+		headers := map[string]string{}
+		for name := range h {
+			headers[name] = h.Get(name)
+		}
+		// Each part is at most MaxPartSize, split evenly so that none is smaller than MinPartSize
+		count := (sizes[i] + MaxPartSize - 1) / MaxPartSize
+		if count == 0 {
+			count = 1
+		}
+		for j := int64(0); j < count; j++ {
+			start, end := sizes[i]*j/count, sizes[i]*(j+1)/count
+			length := end - start
+			if sizes[i] == 0 {
+				// The empty source is copied whole
+				length = -1
+			}
+			part, err := core.CopyObjectPart(ctx, src.Bucket, src.Object, dst.Bucket, dst.Object, uploadID, len(parts)+1, start, length, headers)
+			if err != nil {
+				core.AbortMultipartUpload(context.Background(), dst.Bucket, dst.Object, uploadID)
+				return err
+			}
+			parts = append(parts, part)
+		}
+	}
+
+	complete := minio.PutObjectOptions{ServerSideEncryption: dst.Encryption}
+	ifNoneMatchAny(&complete)
+	if _, err := core.CompleteMultipartUpload(ctx, dst.Bucket, dst.Object, uploadID, parts, complete); err != nil {
+		core.AbortMultipartUpload(context.Background(), dst.Bucket, dst.Object, uploadID)
+		if preconditionFailed(err) {
+			return errTargetExists
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Return a client of an s3 endpoint recording the If-None-Match headers it receives
+func wildcardClient(t *testing.T, received *[][]string) *minio.Client {
+	return signingClient(t, func(w http.ResponseWriter, r *http.Request, payload []byte) {
+		*received = append(*received, r.Header.Values("If-None-Match"))
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>object</Key><ETag>"d41d8cd98f00b204e9800998ecf8427e-1"</ETag></CompleteMultipartUploadResult>`))
+		}
+	})
+}
+
+func TestConditionalPutSendsWildcard(t *testing.T) {
+	defer func(mode string) { ifNoneMatch = mode }(ifNoneMatch)
+	for _, tt := range []struct {
+		mode      string
+		temporary bool
+		want      []string
+	}{
+		{FailIfExists, false, []string{"*"}},
+		{SuffixIfExists, false, []string{"*"}},
+		{FailIfExists, true, nil},
+		{"", false, nil},
+	} {
+		ifNoneMatch = tt.mode
+		var received [][]string
+		client := wildcardClient(t, &received)
+		opts := minio.PutObjectOptions{}
+		conditionalPut(&opts, tt.temporary)
+		if _, err := client.PutObject(context.Background(), "bucket", "object", strings.NewReader("data"), 4, opts); err != nil {
+			t.Fatal(err)
+		}
+		if len(received) != 1 || strings.Join(received[0], ",") != strings.Join(tt.want, ",") {
+			t.Errorf("if-none-match %q, temporary %v: sent If-None-Match %q, want %q", tt.mode, tt.temporary, received, tt.want)
+		}
+	}
+}
+
+func TestCompleteIfNoneMatchSendsWildcard(t *testing.T) {
+	var received [][]string
+	core := &minio.Core{Client: wildcardClient(t, &received)}
+	opts := minio.PutObjectOptions{}
+	ifNoneMatchAny(&opts)
+	parts := []minio.CompletePart{{PartNumber: 1, ETag: `"d41d8cd98f00b204e9800998ecf8427e"`}}
+	if _, err := core.CompleteMultipartUpload(context.Background(), "bucket", "object", "upload", parts, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || strings.Join(received[0], ",") != "*" {
+		t.Errorf("sent If-None-Match %q, want *", received)
+	}
+}
+
+func TestCreateStateSendsWildcard(t *testing.T) {
+	defer func(bucket string) { targetBucket = bucket }(targetBucket)
+	targetBucket = "bucket"
+	var received [][]string
+	target := &s3Sink{client: wildcardClient(t, &received)}
+	if _, err := target.createState(context.Background(), LockName, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || strings.Join(received[0], ",") != "*" {
+		t.Errorf("sent If-None-Match %q, want *", received)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+)
+
+// Apply the values of a YAML config file, keyed by flag name, to the flags not given on the command line.
+// A list value sets a repeatable flag once per element.
+func loadConfig(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	// Flags given on the command line override the config file
+	given := givenFlags()
+
+	for key, value := range values {
+		if flag.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("unknown config key %q", key)
+		}
+		if given[key] {
+			continue
+		}
+		elements, ok := value.([]interface{})
+		if !ok {
+			elements = []interface{}{value}
+		}
+		for _, element := range elements {
+			if err := flag.Set(key, fmt.Sprint(element)); err != nil {
+				return fmt.Errorf("invalid config value for %q: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Return the names of the flags that have been set
+func givenFlags() map[string]bool {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
+
+// stringList is the value of a repeatable flag, holding the value given each time
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Return the destructive actions of the run, on which the operator must confirm before it starts
+func destructiveActions() []string {
+	var actions []string
+	if cleanUp {
+		actions = append(actions, "delete the source objects appended from "+sourceBucketPrefix)
+	}
+	if appendTo != "" {
+		actions = append(actions, "rewrite the existing object "+appendObjectName()+" with the source objects appended to it")
+	}
+	if output != "" && output != StdoutOutput && !strings.HasSuffix(output, "/") {
+		name := filepath.FromSlash(strings.TrimPrefix(output, FileScheme))
+		if _, err := os.Stat(name); err == nil {
+			actions = append(actions, "overwrite the existing file "+name)
+		}
+	} else if ifNoneMatch == "" && appendTo == "" && !uniqueTargetName() {
+		actions = append(actions, "overwrite any existing resulting object named "+targetNameText)
+	}
+	return actions
+}
+
+// Return whether each run names its resulting object differently, after its time, run ID or contents
+func uniqueTargetName() bool {
+	for _, field := range []string{".Timestamp", ".Date", ".RunID", ".SHA256"} {
+		if strings.Contains(targetNameText, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ask the operator to confirm the destructive actions of the run on the terminal, unless yes
+func confirmActions() error {
+	actions := destructiveActions()
+	if len(actions) == 0 || yes {
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 || keysFrom == StdoutOutput {
+		return errors.New("the run would " + strings.Join(actions, " and ") + ", confirm with --yes")
+	}
+	fmt.Fprintln(os.Stderr, "Source:", sourceBucketPrefix)
+	if output != "" {
+		fmt.Fprintln(os.Stderr, "Output:", output)
+	} else {
+		fmt.Fprintln(os.Stderr, "Target:", targetBucketPrefix)
+	}
+	fmt.Fprintln(os.Stderr, "The run will:")
+	for _, action := range actions {
+		fmt.Fprintln(os.Stderr, "  -", action)
+	}
+	fmt.Fprint(os.Stderr, "Continue? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return errors.New("not confirmed")
+	}
+	return nil
+}
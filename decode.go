@@ -0,0 +1,190 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"os"
+	"strings"
+)
+
+// AutoDecompress is the decompress-sources value detecting and decompressing compressed source objects
+const AutoDecompress = "auto"
+
+// sourceCodec is a compression of source objects, recognized by the magic bytes starting them
+type sourceCodec struct {
+	magic     []byte
+	extension string
+	reader    func(r io.Reader) (io.ReadCloser, error)
+}
+
+// SourceCodecs are the compressions of source objects decompressed while appending
+var SourceCodecs = []sourceCodec{
+	{[]byte{0x1f, 0x8b}, ".gz", func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, ".zst", func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}},
+	{[]byte("BZh"), ".bz2", func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	}},
+}
+
+// decodedSource is the contents of a source object as appended, closing any decompressor and spool file
+type decodedSource struct {
+	io.Reader
+	closers []func() error
+}
+
+func (d *decodedSource) Close() error {
+	for _, close := range d.closers {
+		close()
+	}
+	return nil
+}
+
+// Return the contents of the source object read from r as they are appended, decompressed when it is compressed
+// and decompress-sources is auto, then with its text normalized, along with the object described to the encoder.
+// A decompressed object is named without its compression extension. A decompressed or normalized object has an
+// unknown size of -1, unless the encoder requires its size and it is spooled to a temporary file.
+func decodeSource(object minio.ObjectInfo, r io.Reader) (minio.ObjectInfo, io.ReadCloser, error) {
+	d := &decodedSource{Reader: r}
+	if decompressSources == AutoDecompress {
+		br := bufio.NewReader(r)
+		d.Reader = br
+		for i := range SourceCodecs {
+			codec := &SourceCodecs[i]
+			if magic, _ := br.Peek(len(codec.magic)); !bytes.Equal(magic, codec.magic) {
+				continue
+			}
+			dr, err := codec.reader(br)
+			if err != nil {
+				return object, nil, err
+			}
+			d.Reader, d.closers = dr, append(d.closers, dr.Close)
+			object.Key = strings.TrimSuffix(object.Key, codec.extension)
+			// The decompressed size is unknown until read
+			object.Size = -1
+			break
+		}
+	}
+	if stripBOM || normalizeNewlines != "" {
+		d.Reader = normalizeText(d.Reader)
+		object.Size = -1
+	}
+
+	if object.Size < 0 && sizeRequired() {
+		f, err := os.CreateTemp("", "object-appender-")
+		if err != nil {
+			d.Close()
+			return object, nil, err
+		}
+		d.closers = append(d.closers, f.Close, func() error { return os.Remove(f.Name()) })
+		size, err := io.Copy(f, d.Reader)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			d.Close()
+			return object, nil, err
+		}
+		d.Reader, object.Size = f, size
+	}
+	return object, d, nil
+}
+
+// Newline normalizations of source objects
+const (
+	// LFNewlines replaces CRLF newlines with LF
+	LFNewlines = "lf"
+	// CRLFNewlines replaces LF newlines not preceded by CR with CRLF
+	CRLFNewlines = "crlf"
+)
+
+// utf8BOM is the byte order mark starting some UTF-8 text
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// Return the text read from r without a leading byte order mark with strip-bom, and with its newlines normalized
+// with normalize-newlines
+func normalizeText(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if stripBOM {
+		if bom, _ := br.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+			br.Discard(len(utf8BOM))
+		}
+	}
+	if normalizeNewlines == "" {
+		return br
+	}
+	return &newlineReader{r: br}
+}
+
+// newlineReader normalizes the newlines read through it to normalize-newlines
+type newlineReader struct {
+	r       *bufio.Reader
+	last    byte
+	pending bool
+}
+
+func (n *newlineReader) Read(p []byte) (int, error) {
+	i := 0
+	for i < len(p) {
+		if n.pending {
+			// The LF following an inserted CR
+			p[i], n.pending = '\n', false
+			i++
+			continue
+		}
+		if i > 0 && n.r.Buffered() == 0 {
+			// Return what is available rather than block
+			break
+		}
+		c, err := n.r.ReadByte()
+		if err != nil {
+			if i > 0 {
+				return i, nil
+			}
+			return 0, err
+		}
+		switch {
+		case c == '\r' && normalizeNewlines == LFNewlines:
+			if next, err := n.r.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+		case c == '\n' && normalizeNewlines == CRLFNewlines && n.last != '\r':
+			c, n.pending = '\r', true
+		}
+		p[i], n.last = c, c
+		if n.pending {
+			n.last = '\n'
+		}
+		i++
+	}
+	return i, nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNewlineReader(t *testing.T) {
+	defer func(mode string) { normalizeNewlines = mode }(normalizeNewlines)
+	for _, tt := range []struct {
+		mode, in, want string
+	}{
+		{LFNewlines, "a\r\nb\r\n", "a\nb\n"},
+		{LFNewlines, "a\r\nb", "a\nb"},
+		{LFNewlines, "a\nb\r\n", "a\nb\n"},
+		{LFNewlines, "a\rb\r", "a\rb\r"},
+		{LFNewlines, "", ""},
+		{CRLFNewlines, "a\nb\n", "a\r\nb\r\n"},
+		{CRLFNewlines, "a\nb", "a\r\nb"},
+		{CRLFNewlines, "a\r\nb\n", "a\r\nb\r\n"},
+		{CRLFNewlines, "\n\n", "\r\n\r\n"},
+		{CRLFNewlines, "", ""},
+	} {
+		normalizeNewlines = tt.mode
+		for name, wrap := range map[string]func(io.Reader) io.Reader{
+			"whole":    func(r io.Reader) io.Reader { return r },
+			"one byte": iotest.OneByteReader,
+		} {
+			got, err := io.ReadAll(wrap(&newlineReader{r: bufio.NewReader(strings.NewReader(tt.in))}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%v, %v read of %q = %q, want %q", tt.mode, name, tt.in, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Compare the source objects listed with those of the manifest of the resulting object name, logging the objects
+// added, removed and changed since, and return the number of differences
+func diffManifest(ctx context.Context, src source, target sink, name string) (int, error) {
+	manifestName := targetPrefix + "/" + strings.TrimPrefix(name, "/") + ManifestExtension
+	data, err := target.getState(ctx, manifestName)
+	if err == nil && data == nil {
+		err = errors.New("no manifest")
+	}
+	var recorded manifestObject
+	if err == nil {
+		err = json.Unmarshal(data, &recorded)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to read manifest %v - %v", manifestName, err), "object", manifestName, "error", err.Error())
+		return 0, err
+	}
+
+	entries := make(map[string]manifestEntry, len(recorded.Objects))
+	for _, e := range recorded.Objects {
+		entries[e.Key] = e
+	}
+	var added, removed, changed int
+	for object := range listSourceObjects(ctx, src, "", true) {
+		if object.Err != nil {
+			slog.Error(fmt.Sprintf("Failed to list: %v - %v", object.Key, object.Err), "key", object.Key, "error", object.Err.Error())
+			return 0, object.Err
+		}
+		e, ok := entries[object.Key]
+		switch {
+		case !ok:
+			log.Printf("Added: %v, size: %v\n", object.Key, object.Size)
+			added++
+		case e.Size != object.Size || (e.ETag != "" && object.ETag != "" && e.ETag != object.ETag):
+			log.Printf("Changed: %v, size: %v, ETag: %v, recorded size: %v, ETag: %v\n", object.Key, object.Size, object.ETag, e.Size, e.ETag)
+			changed++
+		}
+		delete(entries, object.Key)
+	}
+	for _, e := range recorded.Objects {
+		if _, ok := entries[e.Key]; ok {
+			log.Printf("Removed: %v, size: %v\n", e.Key, e.Size)
+			removed++
+		}
+	}
+	log.Printf("Found added: %v, removed: %v, changed: %v since %v\n", added, removed, changed, manifestName)
+	return added + removed + changed, nil
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// PrefetchSize is the most of each source object held in memory while waiting for its turn to be appended
+const PrefetchSize = 1024 * 1024 * 8
+
+// fetch is a source object being downloaded ahead of being appended
+type fetch struct {
+	object minio.ObjectInfo
+	offset int64
+	head   []byte
+	body   io.ReadCloser
+	// metadata is the user metadata of the object, fetched when merging it
+	metadata map[string]string
+	err      error
+	ready    chan struct{}
+}
+
+// Open the object and prefetch up to PrefetchSize bytes of it, closing ready when done
+func (f *fetch) run(ctx context.Context, src source) {
+	defer close(f.ready)
+	start := time.Now()
+	ctx, span := startSpan(ctx, "get", "key", f.object.Key)
+	defer func() {
+		observe("get", time.Since(start))
+		span.end(f.err)
+	}()
+	if preserveMetadataMode == MergeMetadata {
+		var err error
+		if f.metadata, err = objectMetadata(ctx, src, f.object); err != nil {
+			f.err = err
+			return
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		obj, err := src.open(ctx, f.object.Key, f.offset)
+		if err != nil {
+			f.err = err
+			return
+		}
+		head := new(bytes.Buffer)
+		r := verifyObject(obj, f.object, f.offset)
+		if _, err := io.CopyN(head, r, PrefetchSize); err != nil && err != io.EOF {
+			obj.Close()
+			if errors.Is(err, errVerify) && attempt < VerifyRetries {
+				// The object was read whole, so it can be downloaded again before any of it is appended
+				slog.Warn(fmt.Sprintf("Failed to verify object: %v - %v, retrying", f.object.Key, err), "key", f.object.Key, "error", err.Error())
+				slog.Debug(fmt.Sprintf("Retrying %v, attempt %v of %v", f.object.Key, attempt+2, VerifyRetries+1), "key", f.object.Key, "attempt", attempt+2, "error", err.Error())
+				continue
+			}
+			f.err = err
+			return
+		}
+		slog.Debug(fmt.Sprintf("Fetched %v bytes of %v in %v", head.Len(), f.object.Key, time.Since(start)), "key", f.object.Key, "bytes", head.Len(), "duration", time.Since(start).Seconds())
+		f.head, f.body = head.Bytes(), struct {
+			io.Reader
+			io.Closer
+		}{r, obj}
+		return
+	}
+}
+
+// Close the body of the fetched object, if it was opened
+func (f *fetch) close() {
+	if f.body != nil {
+		f.body.Close()
+	}
+}
+
+// Download all objects under the source prefix, writing their contents to w in listing order, rotating to a new
+// resulting object after rotate-size. When resuming, downloading continues from the position recorded in the checkpoint.
+func downloadObjects(ctx context.Context, src source, w io.Writer, rotate rotation) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var startAfter string
+	var first *minio.ObjectInfo
+	if resumeFrom != nil {
+		startAfter = resumeFrom.Key
+		object, err := src.stat(ctx, resumeFrom.Key)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to obtain object: %v - %v", resumeFrom.Key, err), "key", resumeFrom.Key, "error", err.Error())
+			return err
+		}
+		if resumeFrom.Offset < object.Size {
+			first = &object
+		}
+	}
+
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		if first != nil {
+			objects <- *first
+		}
+		for object := range listSourceObjects(ctx, src, startAfter, true) {
+			select {
+			case objects <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return appendObjects(ctx, src, objects, w, rotate)
+}
+
+// Append the source objects received from objects, writing their contents to w in the order received.
+// Up to downloadConcurrency objects are fetched in parallel, each holding at most PrefetchSize bytes in memory.
+// When resuming, the object recorded in the checkpoint is appended from the recorded offset. With rotate, once
+// rotate-size has been written the next object starts a new resulting object.
+func appendObjects(ctx context.Context, src source, objects <-chan minio.ObjectInfo, w io.Writer, rotate rotation) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Offset within the resulting object at which this run starts writing
+	var committed int64
+	if resumeFrom != nil {
+		committed = int64(len(resumeFrom.Parts)) * int64(resumeFrom.PartSize)
+	}
+
+	// Fetches are queued in order, and each holds a slot until appended
+	slots := make(chan struct{}, downloadConcurrency)
+	queue := make(chan *fetch, downloadConcurrency)
+	go func() {
+		defer close(queue)
+		for object := range objects {
+			f := &fetch{object: object, ready: make(chan struct{})}
+			if resumeFrom != nil && object.Key == resumeFrom.Key {
+				f.offset = resumeFrom.Offset
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			queue <- f
+			if object.Err != nil {
+				f.err = object.Err
+				close(f.ready)
+				return
+			}
+			go f.run(ctx, src)
+		}
+	}()
+	// On an early return, stop queueing and close the bodies of the objects already fetched
+	defer func() {
+		cancel()
+		for f := range queue {
+			<-f.ready
+			f.close()
+		}
+	}()
+
+	out := &countingWriter{w: w}
+	enc, err := newEncoder(out)
+	if err != nil {
+		return err
+	}
+	for f := range queue {
+		<-f.ready
+		if f.object.Err != nil {
+			slog.Error(fmt.Sprintf("Failed to list: %v - %v", f.object.Key, f.err), "key", f.object.Key, "error", f.err.Error())
+			return f.err
+		}
+		if rotate != nil && rotateSize > 0 && objectCount > 0 && uint64(objectSize) >= rotateSize {
+			if err := enc.close(); err != nil {
+				f.close()
+				slog.Error(fmt.Sprintf("Failed to append objects - %v", err), "error", err.Error())
+				return err
+			}
+			slog.Info(fmt.Sprintf("Found objects: %v, size: %v", objectCount, objectSize), "objects", objectCount, "bytes", objectSize)
+			log.Println("Reached rotate-size, continuing in a new resulting object")
+			if w, err = rotate(); err != nil {
+				f.close()
+				return err
+			}
+			out = &countingWriter{w: w}
+			if enc, err = newEncoder(out); err != nil {
+				f.close()
+				return err
+			}
+		}
+		objectCount++
+		if !quiet {
+			slog.Info("Obtaining: "+f.object.Key, "key", f.object.Key, "bytes", f.object.Size-f.offset)
+		}
+		appendStart := time.Now()
+		if f.err != nil {
+			slog.Error(fmt.Sprintf("Failed to obtain object: %v - %v", f.object.Key, f.err), "key", f.object.Key, "error", f.err.Error())
+			objectsFailed.Add(1)
+			return f.err
+		}
+		if f.offset == 0 {
+			if err := checkGzipMember(f.object.Key, f.head); err != nil {
+				f.body.Close()
+				slog.Error(fmt.Sprintf("Failed to append object: %v - %v", f.object.Key, err), "key", f.object.Key, "error", err.Error())
+				objectsFailed.Add(1)
+				return err
+			}
+		}
+		beginObject(f.object, committed+objectSize-f.offset)
+		object, r, err := decodeSource(f.object, io.MultiReader(bytes.NewReader(f.head), f.body))
+		if err != nil {
+			f.body.Close()
+			slog.Error(fmt.Sprintf("Failed to decompress object: %v - %v", f.object.Key, err), "key", f.object.Key, "error", err.Error())
+			objectsFailed.Add(1)
+			return err
+		}
+		if objectCount == 1 {
+			var head []byte
+			if f.offset == 0 && object.Key == f.object.Key {
+				// The contents are appended as they are stored
+				head = f.head
+			}
+			detectContentType(object.Key, head)
+		}
+		offset := out.n
+		n, err := enc.write(object, r)
+		r.Close()
+		f.body.Close()
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to append object: %v - %v", f.object.Key, err), "key", f.object.Key, "error", err.Error())
+			objectsFailed.Add(1)
+			return err
+		}
+		objectSize += n
+		bytesDownloaded.Add(f.object.Size - f.offset)
+		slog.Debug(fmt.Sprintf("Appended %v bytes of %v in %v", n, f.object.Key, time.Since(appendStart)), "key", f.object.Key, "bytes", n, "duration", time.Since(appendStart).Seconds())
+		addManifest(f.object, offset, out.n-offset)
+		switch {
+		case preserveMetadataMode == MergeMetadata:
+			preserveMetadata(f.metadata)
+		case preserveMetadataMode == FirstMetadata && objectCount == 1:
+			metadata, err := objectMetadata(ctx, src, f.object)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to obtain metadata: %v - %v", f.object.Key, err), "key", f.object.Key, "error", err.Error())
+				return err
+			}
+			preserveMetadata(metadata)
+		}
+		<-slots
+	}
+	if objectCount == 0 && resumeFrom == nil {
+		slog.Error("Failed to find objects - exiting")
+		return errNoObjects
+	}
+	if err := enc.close(); err != nil {
+		slog.Error(fmt.Sprintf("Failed to append objects - %v", err), "error", err.Error())
+		return err
+	}
+	slog.Info(fmt.Sprintf("Found objects: %v, size: %v", objectCount, objectSize), "objects", objectCount, "bytes", objectSize)
+
+	return nil
+}
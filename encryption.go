@@ -0,0 +1,97 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// sse values, selecting the server-side encryption of the resulting object
+const (
+	// SSES3 encrypts with keys managed by the server (SSE-S3)
+	SSES3 = "s3"
+	// SSEKMS encrypts with a key of the KMS of the server (SSE-KMS)
+	SSEKMS = "kms"
+)
+
+var (
+	// targetEncryption is the server-side encryption of the resulting object, or nil
+	targetEncryption encrypt.ServerSide
+	// sourceEncryption is the SSE-C encryption of the source objects, or nil
+	sourceEncryption encrypt.ServerSide
+)
+
+// Set the server-side encryption of the resulting object from sse and sse-kms-key-id, or target-sse-c-key, and
+// the encryption of the source objects from source-sse-c-key
+func parseEncryption() error {
+	if sseKMSKeyID != "" && sse == "" {
+		sse = SSEKMS
+	}
+	var err error
+	if sourceSSECKey != "" {
+		if sourceEncryption, err = parseSSECKey("source-sse-c-key", sourceSSECKey); err != nil {
+			return err
+		}
+	}
+	if targetSSECKey != "" {
+		if sse != "" {
+			return errors.New("target-sse-c-key cannot be combined with sse")
+		}
+		targetEncryption, err = parseSSECKey("target-sse-c-key", targetSSECKey)
+		return err
+	}
+	switch sse {
+	case "":
+	case SSES3:
+		targetEncryption = encrypt.NewSSE()
+	case SSEKMS:
+		// Without a key ID, the default key of the KMS is used
+		targetEncryption, err = encrypt.NewSSEKMS(sseKMSKeyID, nil)
+	default:
+		return errors.New("sse must be s3 or kms")
+	}
+	if sseKMSKeyID != "" && sse != SSEKMS {
+		return errors.New("sse-kms-key-id requires sse kms")
+	}
+	return err
+}
+
+// Return the SSE-C encryption of the base64-encoded 256-bit key given as flag name
+func parseSSECKey(name, key string) (encrypt.ServerSide, error) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("%v is invalid: %v", name, err)
+	}
+	sse, err := encrypt.NewSSEC(b)
+	if err != nil {
+		return nil, fmt.Errorf("%v is invalid: %v", name, err)
+	}
+	return sse, nil
+}
+
+// Return the encryption with which resulting objects are read, the key of SSE-C, or nil as the server decrypts
+// resulting objects encrypted with its own keys
+func targetReadEncryption() encrypt.ServerSide {
+	if targetEncryption != nil && targetEncryption.Type() == encrypt.SSEC {
+		return targetEncryption
+	}
+	return nil
+}
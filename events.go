@@ -0,0 +1,345 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EventTimeout bounds the publication of an event to each publisher
+const EventTimeout = 10 * time.Second
+
+// RollupCreated is the type of the event published once a resulting object is in place
+const RollupCreated = "rollup.created"
+
+// rollupEvent is the event published once a resulting object is in place
+type rollupEvent struct {
+	Type   string `json:"type"`
+	RunID  string `json:"runId"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	// Manifest is the name of the manifest sidecar object, with manifest
+	Manifest string    `json:"manifest,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// publisher publishes events to a message broker or notification service
+type publisher interface {
+	// publish publishes the event, keyed by the name of the resulting object
+	publish(ctx context.Context, key string, event []byte) error
+	// String returns the destination of the events, without credentials
+	String() string
+}
+
+// publishers are the destinations of the events of every publish flag
+var publishers []publisher
+
+// Parse the publish destinations: nats://[user:password@]host:port/subject, kafka-rest://host:port/topic or
+// kafka-rests://host:port/topic through a Kafka REST Proxy, and sns://arn:aws:sns:region:account:topic
+func parsePublishers() error {
+	for _, destination := range publishList {
+		// Topic ARNs are not valid URL hosts
+		if arn, ok := strings.CutPrefix(destination, "sns://"); ok {
+			p, err := newSNSPublisher(arn)
+			if err != nil {
+				return err
+			}
+			publishers = append(publishers, p)
+			continue
+		}
+		u, err := url.Parse(destination)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(u.Path, "/")
+		switch {
+		case u.Scheme == "nats" && name != "":
+			publishers = append(publishers, &natsPublisher{address: u.Host, subject: name, user: u.User})
+		case u.Scheme == "kafka":
+			// The Kafka protocol of brokers is not spoken
+			return fmt.Errorf("invalid destination %v, Kafka brokers are not supported, publish through a Kafka REST Proxy with kafka-rest://host:port/topic", destination)
+		case (u.Scheme == "kafka-rest" || u.Scheme == "kafka-rests") && name != "":
+			scheme := "http"
+			if u.Scheme == "kafka-rests" {
+				scheme = "https"
+			}
+			publishers = append(publishers, &kafkaPublisher{url: scheme + "://" + u.Host + "/topics/" + url.PathEscape(name)})
+		default:
+			return fmt.Errorf("invalid destination %v", destination)
+		}
+	}
+	return nil
+}
+
+// Publish the event of the resulting object put in place, with its size and the name of its manifest
+func publishRollup() {
+	if len(publishers) == 0 {
+		return
+	}
+	e := rollupEvent{Type: RollupCreated, RunID: runID, Bucket: targetBucket, Key: targetObjectName, Size: stagedSize, Time: time.Now().UTC()}
+	if stagedSize < 0 {
+		// Composed on the server, as large as the source objects
+		e.Size = objectSize
+	}
+	if output != "" {
+		e.Bucket = ""
+	}
+	if writeManifest {
+		e.Manifest = targetObjectName + ManifestExtension
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to publish event of %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return
+	}
+	for _, p := range publishers {
+		ctx, cancel := context.WithTimeout(context.Background(), EventTimeout)
+		if err := p.publish(ctx, targetObjectName, data); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to publish event of %v to %v - %v", targetObjectName, p, err), "object", targetObjectName, "destination", p.String(), "error", err.Error())
+		}
+		cancel()
+	}
+}
+
+// Send the request with client, failing unless it succeeds
+func doEventRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		return fmt.Errorf("%v %v", resp.Status, strings.TrimSpace(body))
+	}
+	return nil
+}
+
+// natsPublisher publishes events to a subject of a NATS server, with its text protocol
+type natsPublisher struct {
+	address, subject string
+	user             *url.Userinfo
+}
+
+func (p *natsPublisher) String() string {
+	return "nats://" + p.address + "/" + p.subject
+}
+
+func (p *natsPublisher) publish(ctx context.Context, key string, event []byte) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	// The server greets with its INFO
+	if _, err := r.ReadString('\n'); err != nil {
+		return err
+	}
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "object-appender"}
+	if p.user != nil {
+		if password, ok := p.user.Password(); ok {
+			options["user"], options["pass"] = p.user.Username(), password
+		} else {
+			options["auth_token"] = p.user.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	// PING is answered with PONG once the messages before it are processed, or with an -ERR
+	fmt.Fprintf(conn, "CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", connect, p.subject, len(event), event)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(line)
+		}
+	}
+}
+
+// kafkaPublisher publishes events to a Kafka topic through a Kafka REST Proxy
+type kafkaPublisher struct {
+	url string
+}
+
+func (p *kafkaPublisher) String() string {
+	return p.url
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, key string, event []byte) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": key, "value": json.RawMessage(event)}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	return doEventRequest(http.DefaultClient, req)
+}
+
+// snsPublisher publishes events to an Amazon SNS topic
+type snsPublisher struct {
+	topicARN, region string
+	client           *http.Client
+	creds            *credentials.Credentials
+}
+
+// credentialsProvider provides the credentials of the target connection to a credentials chain
+type credentialsProvider struct {
+	creds *credentials.Credentials
+}
+
+func (p credentialsProvider) Retrieve() (credentials.Value, error) {
+	return p.creds.Get()
+}
+
+func (p credentialsProvider) IsExpired() bool {
+	return p.creds.IsExpired()
+}
+
+// Return the publisher of the SNS topic arn, with the credentials of the target connection, given or obtained
+// with web-identity-token-file or role-arn, followed by the environment, the shared credentials file and the
+// instance metadata, failing if none resolve
+func newSNSPublisher(arn string) (*snsPublisher, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || !strings.HasPrefix(arn, "arn:aws:sns:") {
+		return nil, fmt.Errorf("invalid topic ARN %v", arn)
+	}
+	tr, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+	creds, err := newCredentials(tr, targetConnection)
+	if err != nil {
+		return nil, err
+	}
+	p := &snsPublisher{topicARN: arn, region: parts[3], client: &http.Client{Transport: tr}}
+	p.creds = credentials.NewChainCredentials([]credentials.Provider{
+		credentialsProvider{creds: creds},
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: p.client},
+	})
+	if value, err := p.creds.Get(); err != nil || value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return nil, fmt.Errorf("no credentials to publish to %v", arn)
+	}
+	return p, nil
+}
+
+func (p *snsPublisher) String() string {
+	return "sns://" + p.topicARN
+}
+
+func (p *snsPublisher) publish(ctx context.Context, key string, event []byte) error {
+	value, err := p.creds.Get()
+	if err != nil {
+		return err
+	}
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return errors.New("no credentials")
+	}
+	form := url.Values{
+		"Action":   {"Publish"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {p.topicARN},
+		"Subject":  {RollupCreated},
+		"Message":  {string(event)},
+	}
+	body := []byte(form.Encode())
+	host := "sns." + p.region + ".amazonaws.com"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signSNS(req, body, host, p.region, value)
+	return doEventRequest(p.client, req)
+}
+
+// Sign the request to SNS with AWS Signature Version 4 and the credentials value. The signer of minio-go only
+// signs for the s3 service, which SNS rejects.
+func signSNS(req *http.Request, body []byte, host, region string, value credentials.Value) {
+	now := time.Now().UTC()
+	date, amzDate := now.Format("20060102"), now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if value.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", value.SessionToken)
+	}
+	headers := map[string]string{"host": host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	payload := sha256.Sum256(body)
+	canonical := strings.Join([]string{req.Method, "/", "", canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payload[:])}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + region + "/sns/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	sign := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	key := []byte("AWS4" + value.SecretAccessKey)
+	for _, part := range []string{date, region, "sns", "aws4_request"} {
+		key = sign(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		value.AccessKeyID, scope, signedHeaders, hex.EncodeToString(sign(key, stringToSign))))
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePublishersKafka(t *testing.T) {
+	defer func(list []string) { publishList, publishers = list, nil }(publishList)
+	for _, tt := range []struct {
+		destination, want, wantErr string
+	}{
+		{"kafka-rest://proxy:8082/rollups", "http://proxy:8082/topics/rollups", ""},
+		{"kafka-rests://proxy:8082/rollups", "https://proxy:8082/topics/rollups", ""},
+		{"kafka://broker:9092/rollups", "", "Kafka REST Proxy"},
+		{"kafka-rest://proxy:8082", "", "invalid destination"},
+	} {
+		publishList, publishers = []string{tt.destination}, nil
+		err := parsePublishers()
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePublishers(%v) = %v, want an error containing %q", tt.destination, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("parsePublishers(%v) = %v", tt.destination, err)
+		case len(publishers) != 1 || publishers[0].(*kafkaPublisher).url != tt.want:
+			t.Errorf("parsePublishers(%v) published to %v, want %v", tt.destination, publishers, tt.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StdoutOutput is the output writing the resulting object to stdout
+const StdoutOutput = "-"
+
+// fileSink writes the resulting object to a local file, or to stdout, keeping state objects in the local directory.
+// An output path ending with a separator is a directory, in which each run writes a new resulting object.
+type fileSink struct {
+	path string
+	dir  string
+}
+
+// Create the sink of an output of the form file:///path/result, file:///path/dir/ or -
+func newFileSink(output string) (*fileSink, error) {
+	if output == StdoutOutput {
+		return &fileSink{dir: "."}, nil
+	}
+	if !strings.HasPrefix(output, FileScheme) {
+		return nil, errors.New("output must be - or of the form file:///path/result")
+	}
+	name := filepath.FromSlash(strings.TrimPrefix(output, FileScheme))
+	if strings.HasSuffix(output, "/") {
+		return &fileSink{dir: filepath.Clean(name)}, nil
+	}
+	return &fileSink{path: filepath.Clean(name), dir: filepath.Dir(name)}, nil
+}
+
+func (s *fileSink) upload(ctx context.Context, r io.Reader) error {
+	if s.path == "" && s.dir == "." {
+		log.Printf("Writing %s to stdout\n", targetObjectName)
+		if _, err := io.Copy(os.Stdout, r); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+			return err
+		}
+		return nil
+	}
+
+	name := s.path
+	if name == "" {
+		name = filepath.Join(s.dir, filepath.FromSlash(targetObjectName))
+	}
+	log.Printf("Writing %s to %s\n", targetObjectName, name)
+	if err := s.writeFile(name, r, ifNoneMatch != "" && !temporaryTarget()); err != nil {
+		slog.Error(fmt.Sprintf("Failed to write object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return err
+	}
+	slog.Info(fmt.Sprintf("Successfully wrote %s to %s", targetObjectName, name), "object", targetObjectName)
+	return nil
+}
+
+// Write the contents of r to a temporary file renamed to name once complete, so that no partial file is seen,
+// failing with errTargetExists if exclusive and name exists
+func (s *fileSink) writeFile(name string, r io.Reader, exclusive bool) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return placeFile(f.Name(), name, exclusive)
+}
+
+// Rename the file from to to, failing with errTargetExists if exclusive and to exists
+func placeFile(from, to string, exclusive bool) error {
+	if !exclusive {
+		return os.Rename(from, to)
+	}
+	// Linking fails if the file exists, unlike renaming
+	err := os.Link(from, to)
+	if errors.Is(err, os.ErrExist) {
+		return errTargetExists
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+func (s *fileSink) rename(ctx context.Context, from, to string) error {
+	if s.path != "" || s.dir == "." {
+		// The resulting object is not named after targetObjectName
+		return nil
+	}
+	name := filepath.Join(s.dir, filepath.FromSlash(to))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return placeFile(filepath.Join(s.dir, filepath.FromSlash(from)), name, ifNoneMatch != "")
+}
+
+func (s *fileSink) exists(ctx context.Context, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *fileSink) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if s.path == "" && s.dir == "." {
+		return nil, errors.New("cannot read the resulting object from stdout")
+	}
+	if s.path != "" {
+		return os.Open(s.path)
+	}
+	return os.Open(filepath.Join(s.dir, filepath.FromSlash(name)))
+}
+
+func (s *fileSink) list(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		dir := path.Dir(prefix + "x")
+		root := filepath.Join(s.dir, filepath.FromSlash(dir))
+		err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(root, name)
+			if err != nil {
+				return err
+			}
+			key := path.Join(dir, filepath.ToSlash(rel))
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			select {
+			case objects <- minio.ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && ctx.Err() == nil {
+			objects <- minio.ObjectInfo{Key: prefix, Err: err}
+		}
+	}()
+	return objects
+}
+
+func (s *fileSink) getState(ctx context.Context, name string) ([]byte, error) {
+	data, _, err := s.getStateVersion(ctx, name)
+	return data, err
+}
+
+// The version of a state file is the SHA-256 of its contents
+func (s *fileSink) getStateVersion(ctx context.Context, name string) ([]byte, string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+func (s *fileSink) putState(ctx context.Context, name string, data []byte) error {
+	return s.writeFile(filepath.Join(s.dir, filepath.FromSlash(name)), strings.NewReader(string(data)), false)
+}
+
+func (s *fileSink) createState(ctx context.Context, name string, data []byte) (bool, error) {
+	name = filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return false, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	// Linking fails if the state object exists, unlike renaming
+	err = os.Link(f.Name(), name)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// The state file is compared and replaced by one run at a time, holding the lock file <name>.replacing created
+// exclusively, and replaced by renaming over it so that it never goes missing. A lock file left by a run that
+// crashed is removed once older than lock-ttl.
+func (s *fileSink) replaceState(ctx context.Context, name string, data []byte, version string) (bool, error) {
+	replacing := filepath.Join(s.dir, filepath.FromSlash(name)) + ".replacing"
+	f, err := os.OpenFile(replacing, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		if info, err := os.Stat(replacing); err == nil && time.Since(info.ModTime()) > lockTTL {
+			os.Remove(replacing)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(replacing)
+	_, current, err := s.getStateVersion(ctx, name)
+	if err != nil || current == "" || current != version {
+		return false, err
+	}
+	return true, s.putState(ctx, name, data)
+}
+
+func (s *fileSink) removeState(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(s.dir, filepath.FromSlash(name)))
+}
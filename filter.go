@@ -0,0 +1,194 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Compiled include, exclude and key-regex patterns
+var (
+	includePatterns, excludePatterns []*regexp.Regexp
+	keyPattern                       *regexp.Regexp
+	tagFilters                       map[string]string
+	metadataFilters                  map[string]string
+)
+
+// UserMetadataPrefix is the header prefix of user metadata, optional in metadata filters
+const UserMetadataPrefix = "x-amz-meta-"
+
+// Compile the include and exclude glob patterns and the key regex
+func compileFilters() error {
+	if keyRegex != "" {
+		re, err := regexp.Compile(keyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid key-regex: %v", err)
+		}
+		keyPattern = re
+	}
+	for _, filter := range metadataFilterList {
+		key, value, ok := strings.Cut(filter, "=")
+		key = metadataName(key)
+		if !ok || key == "" {
+			return fmt.Errorf("invalid metadata-filter %q, must be name=value", filter)
+		}
+		if metadataFilters == nil {
+			metadataFilters = map[string]string{}
+		}
+		if _, ok := metadataFilters[key]; ok {
+			return fmt.Errorf("metadata-filter given more than once for %q", key)
+		}
+		metadataFilters[key] = value
+	}
+	for _, filter := range tagFilterList {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid tag-filter %q, must be key=value", filter)
+		}
+		if tagFilters == nil {
+			tagFilters = map[string]string{}
+		}
+		if _, ok := tagFilters[key]; ok {
+			return fmt.Errorf("tag-filter given more than once for tag %q", key)
+		}
+		tagFilters[key] = value
+	}
+	for _, list := range []struct {
+		globs    stringList
+		patterns *[]*regexp.Regexp
+	}{{includes, &includePatterns}, {excludes, &excludePatterns}} {
+		for _, glob := range list.globs {
+			re, err := globRegexp(glob)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", glob, err)
+			}
+			*list.patterns = append(*list.patterns, re)
+		}
+	}
+	return nil
+}
+
+// Return the regexp matching whole keys against a glob pattern, in which * matches any sequence of characters
+// including /, ? matches any single character and [...] matches a character class, negated by a leading !
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// Return whether the key matches the key regex and an include pattern, if given, and no exclude pattern
+func matchKey(key string) bool {
+	if keyPattern != nil && !keyPattern.MatchString(key) {
+		return false
+	}
+	for _, re := range excludePatterns {
+		if re.MatchString(key) {
+			return false
+		}
+	}
+	if len(includePatterns) == 0 {
+		return true
+	}
+	for _, re := range includePatterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the values of the named groups of the key regex matched in key
+func keyGroups(key string) map[string]string {
+	groups := map[string]string{}
+	if keyPattern == nil {
+		return groups
+	}
+	match := keyPattern.FindStringSubmatch(key)
+	if match == nil {
+		return groups
+	}
+	for i, name := range keyPattern.SubexpNames() {
+		if name != "" {
+			groups[name] = match[i]
+		}
+	}
+	return groups
+}
+
+// Return whether the tags match every tag filter
+func matchTags(tags map[string]string) bool {
+	for key, value := range tagFilters {
+		if v, ok := tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the user metadata name, case-insensitive and with or without the user metadata header prefix, normalized
+func metadataName(name string) string {
+	name = strings.ToLower(name)
+	return strings.TrimPrefix(name, UserMetadataPrefix)
+}
+
+// Return whether the user metadata matches every metadata filter
+func matchMetadata(metadata map[string]string) bool {
+	normalized := make(map[string]string, len(metadata))
+	for name, value := range metadata {
+		normalized[metadataName(name)] = value
+	}
+	for name, value := range metadataFilters {
+		if v, ok := normalized[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse an RFC3339 time, or a date taken as midnight UTC
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,623 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/parquet-go/parquet-go"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Templates written before and after each source object, executed with its minio.ObjectInfo
+var objectHeader, objectTrailer *template.Template
+
+// Parse the object header and trailer templates, after replacing their escapes
+func parseTemplates() error {
+	for _, t := range []struct {
+		name, text string
+		template   **template.Template
+	}{{"object-header", objectHeaderText, &objectHeader}, {"object-trailer", objectTrailerText, &objectTrailer}} {
+		if t.text == "" {
+			continue
+		}
+		text, err := unescape(t.text)
+		if err != nil {
+			return fmt.Errorf("%v is invalid: %v", t.name, err)
+		}
+		if *t.template, err = template.New(t.name).Option("missingkey=error").Parse(text); err != nil {
+			return fmt.Errorf("%v is invalid: %v", t.name, err)
+		}
+	}
+	return nil
+}
+
+// encoder writes the source objects appended to the resulting object
+type encoder interface {
+	// write writes the source object whose contents are read from r, returning the number of bytes read
+	write(object minio.ObjectInfo, r io.Reader) (int64, error)
+	// close writes anything following the last source object
+	close() error
+}
+
+// LengthPrefixedFraming is the framing writing each source object after a header giving its key and length
+const LengthPrefixedFraming = "length-prefixed"
+
+// GzipMembersFormat is the format concatenating gzip source objects unchanged into a multi-member gzip
+const GzipMembersFormat = "gzip-members"
+
+// gzipMagic starts every gzip member
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// outputFormat is the extension and content type of the resulting object in a format
+type outputFormat struct {
+	extension, contentType string
+}
+
+// Formats maps the format values to the resulting objects written in that format
+var Formats = map[string]outputFormat{
+	"tar": {".tar", "application/x-tar"},
+	"zip": {".zip", "application/zip"},
+	// Gzip streams are concatenable, so gzip sources are appended unchanged
+	GzipMembersFormat: {".gz", "application/gzip"},
+	"ndjson":          {".ndjson", "application/x-ndjson"},
+	"csv-merge":       {".csv", "text/csv"},
+	"json-array":      {".json", "application/json"},
+	"parquet":         {".parquet", "application/vnd.apache.parquet"},
+	"avro":            {".avro", "application/avro"},
+}
+
+// Return the encoder writing the resulting object to w, compressed if configured
+func newEncoder(w io.Writer) (encoder, error) {
+	if !clientEncrypted() {
+		return newCompressedEncoder(w)
+	}
+	e, err := age.Encrypt(w, ageRecipients...)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := newCompressedEncoder(e)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedEncoder{encoder: enc, e: e}, nil
+}
+
+// Return the encoder writing the source objects to w in the configured format, compressed with compress
+func newCompressedEncoder(w io.Writer) (encoder, error) {
+	if compress == "" {
+		return newFormatEncoder(w), nil
+	}
+	c, err := newCompressor(w)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedEncoder{encoder: newFormatEncoder(c), c: c}, nil
+}
+
+// Return the encoder writing the source objects to w in the configured format
+func newFormatEncoder(w io.Writer) encoder {
+	switch {
+	case format == "tar":
+		return &tarEncoder{w: tar.NewWriter(w)}
+	case format == "zip":
+		return &zipEncoder{w: zip.NewWriter(w)}
+	case format == "csv-merge":
+		return &csvEncoder{w: csv.NewWriter(w)}
+	case format == "json-array":
+		return &jsonArrayEncoder{w: w}
+	case format == "parquet":
+		return &parquetEncoder{w: parquet.NewWriter(w)}
+	case format == "avro":
+		return &avroEncoder{w: w}
+	case format == "ndjson":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return &ndjsonEncoder{w: enc}
+	case framing == LengthPrefixedFraming:
+		return &framedEncoder{w: w}
+	}
+	return &rawEncoder{w: w}
+}
+
+// Return the extension of the resulting object name
+func outputExtension() string {
+	extension := Formats[format].extension + Compressions[compress].extension
+	if clientEncrypted() {
+		extension += AgeExtension
+	}
+	return extension
+}
+
+// ContentTypes are the content types of source object extensions known regardless of the system MIME types
+var ContentTypes = map[string]string{
+	".csv":     "text/csv",
+	".tsv":     "text/tab-separated-values",
+	".txt":     "text/plain",
+	".log":     "text/plain",
+	".json":    "application/json",
+	".ndjson":  "application/x-ndjson",
+	".jsonl":   "application/x-ndjson",
+	".xml":     "application/xml",
+	".gz":      "application/gzip",
+	".zst":     "application/zstd",
+	".bz2":     "application/x-bzip2",
+	".parquet": "application/vnd.apache.parquet",
+}
+
+// detectedType is the content type detected from the first source object appended to the resulting object
+var detectedType string
+
+// Detect the content type of raw output from the key of the first source object appended, or else from its
+// first bytes
+func detectContentType(key string, head []byte) {
+	detectedType = ""
+	ext := strings.ToLower(path.Ext(key))
+	if t, ok := ContentTypes[ext]; ok {
+		detectedType = t
+	} else if t := mime.TypeByExtension(ext); ext != "" && t != "" {
+		detectedType = t
+	} else if len(head) > 0 {
+		detectedType = http.DetectContentType(head)
+	}
+	if detectedType == ContentType {
+		detectedType = ""
+	}
+}
+
+// Return the content type of the resulting object: content-type, that of the format, or that detected from the
+// source objects
+func contentType() string {
+	switch f, ok := Formats[format]; {
+	case contentTypeOverride != "":
+		return contentTypeOverride
+	case clientEncrypted():
+	case ok:
+		return f.contentType
+	case detectedType != "":
+		return detectedType
+	}
+	return ContentType
+}
+
+// Check that the output options can be combined
+func validateOutput() error {
+	if framing != "" && framing != LengthPrefixedFraming {
+		return fmt.Errorf("framing must be %v", LengthPrefixedFraming)
+	}
+	if _, ok := Formats[format]; format != "" && !ok {
+		return fmt.Errorf("format %v is invalid", format)
+	}
+	if (framing != "" || format != "") && (separator != "" || objectHeader != nil || objectTrailer != nil || ensureNewline) {
+		return errors.New("framing and format cannot be combined with separator, object-header, object-trailer or ensure-newline")
+	}
+	if framing != "" && format != "" {
+		return errors.New("framing cannot be combined with format")
+	}
+	if format == GzipMembersFormat && (compress != "" || decompressSources != "" || stripBOM || normalizeNewlines != "") {
+		return errors.New("format gzip-members cannot be combined with compress, decompress-sources, strip-bom or normalize-newlines")
+	}
+	if normalizeNewlines != "" && normalizeNewlines != LFNewlines && normalizeNewlines != CRLFNewlines {
+		return fmt.Errorf("normalize-newlines must be %v or %v", LFNewlines, CRLFNewlines)
+	}
+	return nil
+}
+
+// Return whether the resulting object is the unchanged concatenation of the source objects, so that its
+// offsets map to offsets within the source objects, as composing and resuming require
+func rawOutput() bool {
+	return separator == "" && objectHeader == nil && objectTrailer == nil && !ensureNewline && framing == "" &&
+		(format == "" || format == GzipMembersFormat) && compress == "" && decompressSources == "" &&
+		!stripBOM && normalizeNewlines == "" && !clientEncrypted()
+}
+
+// Check that a source object appended from its start is a gzip member given its first bytes, in the gzip-members
+// format
+func checkGzipMember(key string, head []byte) error {
+	if format == GzipMembersFormat && !bytes.HasPrefix(head, gzipMagic) {
+		return fmt.Errorf("object %v is not gzip compressed", key)
+	}
+	return nil
+}
+
+// Return whether the encoder writes the size of each source object before its contents
+func sizeRequired() bool {
+	return framing != "" || format == "tar"
+}
+
+// rawEncoder concatenates the source objects, writing separator between them, and the object header and
+// trailer around each of them. With ensure-newline, a newline is added to objects not ending with one.
+type rawEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *rawEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	if e.count > 0 && separator != "" {
+		if _, err := io.WriteString(e.w, separator); err != nil {
+			return 0, err
+		}
+	}
+	e.count++
+	if objectHeader != nil {
+		if err := objectHeader.Execute(e.w, object); err != nil {
+			return 0, err
+		}
+	}
+	body := &lastByteWriter{w: e.w}
+	n, err := io.Copy(body, r)
+	if err != nil {
+		return n, err
+	}
+	if ensureNewline && n > 0 && body.last != '\n' {
+		if _, err := io.WriteString(e.w, "\n"); err != nil {
+			return n, err
+		}
+	}
+	if objectTrailer != nil {
+		if err := objectTrailer.Execute(e.w, object); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (e *rawEncoder) close() error {
+	return nil
+}
+
+// framedEncoder writes each source object after a header of the big-endian uint32 length of its key,
+// its key, and the big-endian uint64 length of its contents, so that the objects may be split apart losslessly
+type framedEncoder struct {
+	w io.Writer
+}
+
+func (e *framedEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(object.Key)))
+	header = append(header, object.Key...)
+	header = binary.BigEndian.AppendUint64(header, uint64(object.Size))
+	if _, err := e.w.Write(header); err != nil {
+		return 0, err
+	}
+	// The contents must match the length written in the header
+	return copySize(e.w, r, object)
+}
+
+func (e *framedEncoder) close() error {
+	return nil
+}
+
+// tarEncoder writes each source object as a tar entry named after its key
+type tarEncoder struct {
+	w *tar.Writer
+}
+
+func (e *tarEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	err := e.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     object.Key,
+		Size:     object.Size,
+		Mode:     0o644,
+		ModTime:  object.LastModified,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return copySize(e.w, r, object)
+}
+
+func (e *tarEncoder) close() error {
+	return e.w.Close()
+}
+
+// zipEncoder writes each source object as a deflated zip entry named after its key
+type zipEncoder struct {
+	w *zip.Writer
+}
+
+func (e *zipEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	w, err := e.w.CreateHeader(&zip.FileHeader{
+		Name:     object.Key,
+		Method:   zip.Deflate,
+		Modified: object.LastModified,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return copySize(w, r, object)
+}
+
+func (e *zipEncoder) close() error {
+	return e.w.Close()
+}
+
+// ndjsonRecord is the line written for each source object in the ndjson format
+type ndjsonRecord struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	// Encoding is text for valid UTF-8 bodies, written as is, or base64
+	Encoding string `json:"encoding"`
+	Body     string `json:"body"`
+}
+
+// ndjsonEncoder writes each source object as a line of JSON holding its key and body, read whole into memory
+type ndjsonEncoder struct {
+	w *json.Encoder
+}
+
+func (e *ndjsonEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(body)), err
+	}
+	record := ndjsonRecord{Key: object.Key, Size: int64(len(body)), LastModified: object.LastModified}
+	if utf8.Valid(body) {
+		record.Encoding, record.Body = "text", string(body)
+	} else {
+		record.Encoding, record.Body = "base64", base64.StdEncoding.EncodeToString(body)
+	}
+	return record.Size, e.w.Encode(record)
+}
+
+func (e *ndjsonEncoder) close() error {
+	return nil
+}
+
+// csvEncoder merges CSV source objects into a single CSV, writing the header row of the first source object only.
+// Every source object must have the same header row, and every row the same number of columns.
+type csvEncoder struct {
+	w      *csv.Writer
+	header []string
+}
+
+func (e *csvEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+	records := csv.NewReader(counter)
+	header, err := records.Read()
+	if err == io.EOF {
+		// Empty objects have no header row
+		return counter.n, nil
+	}
+	if err != nil {
+		return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+	}
+	if e.header == nil {
+		e.header = header
+		if err := e.w.Write(header); err != nil {
+			return counter.n, err
+		}
+	} else if !slices.Equal(header, e.header) {
+		return counter.n, fmt.Errorf("object %v header %q does not match header %q", object.Key, header, e.header)
+	}
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+		if err := e.w.Write(record); err != nil {
+			return counter.n, err
+		}
+	}
+	e.w.Flush()
+	return counter.n, e.w.Error()
+}
+
+func (e *csvEncoder) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonArrayEncoder merges JSON source objects into a single JSON array. The elements of a source array are
+// appended to it, streamed one by one, and any other source values, such as lines of JSON objects, are appended
+// as elements.
+type jsonArrayEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonArrayEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+	br := bufio.NewReader(counter)
+	array := false
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			break
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			br.UnreadByte()
+			array = c == '['
+			break
+		}
+	}
+
+	dec := json.NewDecoder(br)
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+	}
+	for dec.More() {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+		if err := e.element(value); err != nil {
+			return counter.n, err
+		}
+	}
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return counter.n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return counter.n, fmt.Errorf("object %v: unexpected data after array", object.Key)
+		}
+	}
+	return counter.n, nil
+}
+
+// Write a value as the next element of the array
+func (e *jsonArrayEncoder) element(value json.RawMessage) error {
+	delim := ","
+	if e.count == 0 {
+		delim = "["
+	}
+	e.count++
+	if _, err := io.WriteString(e.w, delim); err != nil {
+		return err
+	}
+	_, err := e.w.Write(value)
+	return err
+}
+
+func (e *jsonArrayEncoder) close() error {
+	end := "]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// parquetEncoder merges Parquet source objects sharing a schema into a single Parquet file, copying their row groups.
+// Parquet files are read from their footer, so each source object is spooled to a temporary file.
+type parquetEncoder struct {
+	w     *parquet.Writer
+	count int
+}
+
+func (e *parquetEncoder) write(object minio.ObjectInfo, r io.Reader) (int64, error) {
+	f, err := os.CreateTemp("", "object-appender-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return n, err
+	}
+	file, err := parquet.OpenFile(f, n)
+	if err != nil {
+		return n, fmt.Errorf("object %v: %w", object.Key, err)
+	}
+	for _, rowGroup := range file.RowGroups() {
+		if _, err := e.w.WriteRowGroup(rowGroup); errors.Is(err, parquet.ErrRowGroupSchemaMismatch) {
+			return n, fmt.Errorf("object %v schema does not match the schema of the previous objects", object.Key)
+		} else if err != nil {
+			return n, fmt.Errorf("object %v: %w", object.Key, err)
+		}
+	}
+	e.count++
+	return n, nil
+}
+
+func (e *parquetEncoder) close() error {
+	if e.count == 0 {
+		return errors.New("no parquet objects to merge")
+	}
+	return e.w.Close()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Copy exactly the listed size of the source object from r to w, failing if its contents no longer match it.
+// The contents of an object of unknown size are copied whole.
+func copySize(w io.Writer, r io.Reader, object minio.ObjectInfo) (int64, error) {
+	if object.Size < 0 {
+		return io.Copy(w, r)
+	}
+	n, err := io.CopyN(w, r, object.Size)
+	if err == io.EOF {
+		return n, fmt.Errorf("object %v is shorter than its listed size %v", object.Key, object.Size)
+	}
+	if err != nil {
+		return n, err
+	}
+	if m, _ := r.Read(make([]byte, 1)); m > 0 {
+		return n, fmt.Errorf("object %v is longer than its listed size %v", object.Key, object.Size)
+	}
+	return n, nil
+}
+
+// lastByteWriter records the last byte written through it
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (l *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		l.last = p[len(p)-1]
+	}
+	return l.w.Write(p)
+}
+
+// Return s with its Go escape sequences, such as \n, \t or \x1e, replaced by the characters they denote
+func unescape(s string) (string, error) {
+	var b []byte
+	for len(s) > 0 {
+		value, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return "", err
+		}
+		if multibyte {
+			b = utf8.AppendRune(b, value)
+		} else {
+			b = append(b, byte(value))
+		}
+		s = tail
+	}
+	return string(b), nil
+}
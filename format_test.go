@@ -0,0 +1,46 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import "testing"
+
+func TestUnescape(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{``, "", false},
+		{`,`, ",", false},
+		{`\n`, "\n", false},
+		{`\r\n`, "\r\n", false},
+		{`--\t--`, "--\t--", false},
+		{`\x1e`, "\x1e", false},
+		{`\xff`, "\xff", false},
+		{`é`, "é", false},
+		{`é\n`, "é\n", false},
+		{`\\`, `\`, false},
+		{`\`, "", true},
+		{`\q`, "", true},
+		{`\x1`, "", true},
+	} {
+		got, err := unescape(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("unescape(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ReadyRetry is the interval between the readiness checks of watch and schedule modes failing
+const ReadyRetry = 10 * time.Second
+
+// ready is set once the source has been listed and the target reached with the credentials configured
+var ready atomic.Bool
+
+// Serve /healthz and /readyz on http-addr for the probes of Kubernetes, the handlers of the mux being served as well
+func serveHTTP(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	go func() {
+		if err := http.ListenAndServe(httpAddr, mux); err != nil {
+			slog.Error(fmt.Sprintf("Failed to serve %v - %v", httpAddr, err), "addr", httpAddr, "error", err.Error())
+		}
+	}()
+}
+
+// Check that the source can be listed and the state of the target read, becoming ready once they are, retrying
+// every ReadyRetry until ctx is done
+func checkReady(ctx context.Context, src source, target sink) {
+	for {
+		err := probe(ctx, src, target)
+		if err == nil {
+			ready.Store(true)
+			log.Println("Ready")
+			return
+		}
+		slog.Warn(fmt.Sprintf("Failed readiness check - %v", err), "error", err.Error())
+		timer := time.NewTimer(ReadyRetry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// List the first source object and read the watermark of the target, exercising the credentials of both
+func probe(ctx context.Context, src source, target sink) error {
+	listCtx, cancel := context.WithCancel(ctx)
+	objects := src.list(listCtx, "")
+	object := <-objects
+	cancel()
+	for range objects {
+		// Let the listing stop
+	}
+	if object.Err != nil {
+		return object.Err
+	}
+	_, err := target.getState(ctx, stateObjectName(WatermarkName))
+	return err
+}
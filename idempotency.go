@@ -0,0 +1,115 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InputsName is the name of the state object recording the input set of the previous run with skip-unchanged
+const InputsName = "inputs.json"
+
+var errInputsUnchanged = errors.New("source objects unchanged since the previous run")
+
+// inputsHash is the hash of the input set of the current run, recorded once it succeeds
+var inputsHash string
+
+// inputsState records the input set of the previous successful run
+type inputsState struct {
+	// Hash is the hex SHA-256 of the sorted keys, ETags and sizes of the source objects appended
+	Hash  string    `json:"hash"`
+	RunID string    `json:"runId"`
+	Time  time.Time `json:"time"`
+}
+
+// Return the hash of the input set of the run, the sorted keys, ETags and sizes of the source objects to append
+func hashInputs(ctx context.Context, src source) (string, error) {
+	// Stop listing on an early return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lines []string
+	// The listing is not counted, recorded or logged, as the run lists the source objects again
+	for object := range listSourceObjects(ctx, src, "", false) {
+		if object.Err != nil {
+			slog.Error(fmt.Sprintf("Failed to list: %v - %v", object.Key, object.Err), "key", object.Key, "error", object.Err.Error())
+			return "", object.Err
+		}
+		lines = append(lines, fmt.Sprintf("%s\x00%s\x00%d\n", object.Key, strings.Trim(object.ETag, `"`), object.Size))
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Fail the run with errInputsUnchanged when its input set is the one of the previous successful run, so that
+// a retried job does not write the same resulting object twice
+func checkInputs(ctx context.Context, src source, target sink) error {
+	inputsHash = ""
+	if !skipUnchanged || resumeFrom != nil {
+		return nil
+	}
+	hash, err := hashInputs(ctx, src)
+	if err != nil {
+		return err
+	}
+	data, err := target.getState(ctx, stateObjectName(InputsName))
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to load inputs %v - %v", stateObjectName(InputsName), err), "object", stateObjectName(InputsName), "error", err.Error())
+		return err
+	}
+	var previous inputsState
+	if data != nil {
+		if err := json.Unmarshal(data, &previous); err != nil {
+			slog.Error(fmt.Sprintf("Failed to load inputs %v - %v", stateObjectName(InputsName), err), "object", stateObjectName(InputsName), "error", err.Error())
+			return err
+		}
+	}
+	if hash == previous.Hash {
+		log.Printf("Skipping run, the source objects are unchanged since run %v at %v\n", previous.RunID, previous.Time)
+		return errInputsUnchanged
+	}
+	inputsHash = hash
+	return nil
+}
+
+// Record the input set of the run for the next run with skip-unchanged
+func saveInputs(ctx context.Context, target sink) {
+	if inputsHash == "" {
+		return
+	}
+	data, err := json.Marshal(inputsState{Hash: inputsHash, RunID: runID, Time: runStart})
+	if err == nil {
+		err = target.putState(ctx, stateObjectName(InputsName), data)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to save inputs %v - %v", stateObjectName(InputsName), err), "object", stateObjectName(InputsName), "error", err.Error())
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"testing"
+	"time"
+)
+
+// failingSource lists an object, then a listing error, then objects for as long as they are received
+type failingSource struct {
+	source
+	done chan struct{}
+}
+
+func (s *failingSource) list(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(s.done)
+		defer close(objects)
+		objects <- minio.ObjectInfo{Key: "a", Size: 1}
+		objects <- minio.ObjectInfo{Key: "b", Err: errors.New("listing failed")}
+		for {
+			select {
+			case objects <- minio.ObjectInfo{Key: "c", Size: 1}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return objects
+}
+
+func TestHashInputsStopsListingOnError(t *testing.T) {
+	src := &failingSource{done: make(chan struct{})}
+	listed := objectsListed.Load()
+	if _, err := hashInputs(context.Background(), src); err == nil {
+		t.Fatal("hashInputs succeeded despite the listing error")
+	}
+	select {
+	case <-src.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listing still running after hashInputs returned")
+	}
+	if n := objectsListed.Load() - listed; n != 0 {
+		t.Errorf("hashing counted %v objects listed, want 0", n)
+	}
+}
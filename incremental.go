@@ -0,0 +1,99 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/minio/minio-go/v7"
+	"time"
+)
+
+// WatermarkName is the name of the state object recording the progress of incremental runs
+const WatermarkName = "watermark.json"
+
+// The watermark recorded by the previous incremental run
+var watermark watermarkState
+
+// watermarkState records the progress of incremental runs. When a run was capped by max-objects or max-bytes,
+// the objects remaining after Key are appended by the following runs before the watermark moves on.
+type watermarkState struct {
+	// LastModified is the highest LastModified of the source objects appended so far
+	LastModified time.Time `json:"lastModified"`
+	// LastModifiedKey is the highest key of the source objects appended so far modified at LastModified
+	LastModifiedKey string `json:"lastModifiedKey,omitempty"`
+	// Key is the last source object appended by a capped run, if the previous run was capped
+	Key string `json:"key,omitempty"`
+	// Latest is the highest LastModified of the source objects appended by capped runs since LastModified
+	Latest time.Time `json:"latest"`
+	// LatestKey is the highest key of the source objects appended by capped runs modified at Latest
+	LatestKey string `json:"latestKey,omitempty"`
+}
+
+// Return whether the source object was appended by the previous incremental runs, as it was modified before
+// LastModified, or at it with a key up to LastModifiedKey. A watermark recorded without LastModifiedKey covers
+// every source object modified at LastModified.
+func (w watermarkState) covers(object minio.ObjectInfo) bool {
+	if w.LastModifiedKey == "" {
+		return !object.LastModified.After(w.LastModified)
+	}
+	return !laterModified(object.LastModified, object.Key, w.LastModified, w.LastModifiedKey)
+}
+
+// Return whether a source object modified at t with key comes after one modified at than with thanKey, telling
+// apart objects modified at the same time by their keys
+func laterModified(t time.Time, key string, than time.Time, thanKey string) bool {
+	return t.After(than) || (t.Equal(than) && key > thanKey)
+}
+
+// Load the watermark recorded by the previous incremental run, returning the zero watermark if there is none
+func loadWatermark(ctx context.Context, target sink) (watermarkState, error) {
+	data, err := target.getState(ctx, stateObjectName(WatermarkName))
+	if err != nil || data == nil {
+		return watermarkState{}, err
+	}
+	var w watermarkState
+	if err := json.Unmarshal(data, &w); err != nil {
+		return watermarkState{}, err
+	}
+	return w, nil
+}
+
+// Return the watermark following a run appending objects up to latest, the highest of them modified at latest
+// being latestKey, ending at key if the run was capped
+func nextWatermark(latest time.Time, latestKey, key string) watermarkState {
+	if !laterModified(latest, latestKey, watermark.Latest, watermark.LatestKey) {
+		latest, latestKey = watermark.Latest, watermark.LatestKey
+	}
+	if key != "" {
+		return watermarkState{LastModified: watermark.LastModified, LastModifiedKey: watermark.LastModifiedKey, Key: key, Latest: latest, LatestKey: latestKey}
+	}
+	if !laterModified(latest, latestKey, watermark.LastModified, watermark.LastModifiedKey) {
+		latest, latestKey = watermark.LastModified, watermark.LastModifiedKey
+	}
+	return watermarkState{LastModified: latest, LastModifiedKey: latestKey}
+}
+
+// Save the watermark for the next incremental run
+func saveWatermark(ctx context.Context, target sink, w watermarkState) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return target.putState(ctx, stateObjectName(WatermarkName), data)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/minio/minio-go/v7"
+	"testing"
+	"time"
+)
+
+func TestNextWatermark(t *testing.T) {
+	defer func(w watermarkState) { watermark = w }(watermark)
+	t0 := time.Date(2024, 2, 26, 10, 0, 0, 0, time.UTC)
+	t1, t2 := t0.Add(time.Hour), t0.Add(2*time.Hour)
+	for _, tt := range []struct {
+		name      string
+		previous  watermarkState
+		latest    time.Time
+		latestKey string
+		key       string
+		want      watermarkState
+	}{
+		{"first run", watermarkState{}, t1, "a", "", watermarkState{LastModified: t1, LastModifiedKey: "a"}},
+		{"newer objects", watermarkState{LastModified: t0, LastModifiedKey: "z"}, t1, "a", "", watermarkState{LastModified: t1, LastModifiedKey: "a"}},
+		{"older objects", watermarkState{LastModified: t2, LastModifiedKey: "a"}, t1, "z", "", watermarkState{LastModified: t2, LastModifiedKey: "a"}},
+		{"equal timestamps", watermarkState{LastModified: t1, LastModifiedKey: "b"}, t1, "c", "", watermarkState{LastModified: t1, LastModifiedKey: "c"}},
+		{"equal timestamps, lower key", watermarkState{LastModified: t1, LastModifiedKey: "c"}, t1, "b", "", watermarkState{LastModified: t1, LastModifiedKey: "c"}},
+		{"capped", watermarkState{LastModified: t0, LastModifiedKey: "a"}, t1, "b", "b", watermarkState{LastModified: t0, LastModifiedKey: "a", Key: "b", Latest: t1, LatestKey: "b"}},
+		{"capped again", watermarkState{LastModified: t0, Key: "b", Latest: t2, LatestKey: "a"}, t1, "c", "c", watermarkState{LastModified: t0, Key: "c", Latest: t2, LatestKey: "a"}},
+		{"capped again, equal timestamps", watermarkState{LastModified: t0, Key: "b", Latest: t1, LatestKey: "a"}, t1, "c", "c", watermarkState{LastModified: t0, Key: "c", Latest: t1, LatestKey: "c"}},
+		{"after capped runs", watermarkState{LastModified: t0, Key: "c", Latest: t2, LatestKey: "b"}, t1, "d", "", watermarkState{LastModified: t2, LastModifiedKey: "b"}},
+	} {
+		watermark = tt.previous
+		if got := nextWatermark(tt.latest, tt.latestKey, tt.key); got != tt.want {
+			t.Errorf("%v: nextWatermark(%v, %q, %q) = %+v, want %+v", tt.name, tt.latest, tt.latestKey, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestWatermarkCovers(t *testing.T) {
+	t0 := time.Date(2024, 2, 26, 10, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Second)
+	for _, tt := range []struct {
+		name      string
+		watermark watermarkState
+		modified  time.Time
+		key       string
+		want      bool
+	}{
+		{"no watermark", watermarkState{}, t0, "a", false},
+		{"older", watermarkState{LastModified: t1, LastModifiedKey: "a"}, t0, "z", true},
+		{"newer", watermarkState{LastModified: t0, LastModifiedKey: "z"}, t1, "a", false},
+		{"equal timestamps, appended", watermarkState{LastModified: t0, LastModifiedKey: "b"}, t0, "b", true},
+		{"equal timestamps, lower key", watermarkState{LastModified: t0, LastModifiedKey: "b"}, t0, "a", true},
+		{"equal timestamps, higher key", watermarkState{LastModified: t0, LastModifiedKey: "b"}, t0, "c", false},
+		{"equal timestamps, no key recorded", watermarkState{LastModified: t0}, t0, "c", true},
+	} {
+		if got := tt.watermark.covers(minio.ObjectInfo{Key: tt.key, LastModified: tt.modified}); got != tt.want {
+			t.Errorf("%v: covers(%v, %q) = %v, want %v", tt.name, tt.modified, tt.key, got, tt.want)
+		}
+	}
+}
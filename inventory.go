@@ -0,0 +1,273 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultInventorySchema is the columns of an inventory CSV given without a manifest or header row
+const DefaultInventorySchema = "Bucket, Key, Size, LastModifiedDate, ETag"
+
+// inventoryManifest is the manifest.json of an S3 Inventory report
+type inventoryManifest struct {
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// inventorySource reads the source objects listed in an inventory from an s3 bucket, in key order.
+// Each object is checked to still match the size and ETag recorded in the inventory when read.
+type inventorySource struct {
+	client  *minio.Client
+	objects []minio.ObjectInfo
+}
+
+// Return the source of the objects under the source prefix listed in the inventory at location, either
+// bucket/key or file:///path of an S3 Inventory manifest.json, or of a single inventory CSV
+func newInventorySource(ctx context.Context, client *minio.Client, location string) (*inventorySource, error) {
+	s := &inventorySource{client: client}
+	if !strings.HasSuffix(location, ".json") {
+		if err := s.readCSV(ctx, location, "", false); err != nil {
+			return nil, err
+		}
+	} else {
+		r, err := openInventoryFile(ctx, client, location)
+		if err != nil {
+			return nil, err
+		}
+		var manifest inventoryManifest
+		err = json.NewDecoder(r).Decode(&manifest)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(manifest.FileFormat, "CSV") {
+			return nil, fmt.Errorf("unsupported inventory format %v", manifest.FileFormat)
+		}
+		bucket := manifest.DestinationBucket[strings.LastIndex(manifest.DestinationBucket, ":")+1:]
+		for _, file := range manifest.Files {
+			// S3 Inventory reports URL-encode their keys
+			if err := s.readCSV(ctx, bucket+"/"+file.Key, manifest.FileSchema, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Inventory reports are not ordered, list them in key order as a live listing would
+	sort.Slice(s.objects, func(i, j int) bool { return s.objects[i].Key < s.objects[j].Key })
+	log.Printf("Found objects in inventory: %v\n", len(s.objects))
+	return s, nil
+}
+
+// Open the inventory file at location, either bucket/key or file:///path, decompressing .gz files
+func openInventoryFile(ctx context.Context, client *minio.Client, location string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(location, FileScheme) {
+		f, err := os.Open(filepath.FromSlash(strings.TrimPrefix(location, FileScheme)))
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	} else {
+		parts := strings.SplitN(location, "/", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("inventory-manifest must contain a bucket and key")
+		}
+		obj, err := client.GetObject(ctx, parts[0], parts[1], minio.GetObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		r = obj
+	}
+	if !strings.HasSuffix(location, ".gz") {
+		return r, nil
+	}
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{z, r}, nil
+}
+
+// Read the objects under the source bucket and prefix from the inventory CSV at location. Without a schema,
+// the columns are taken from a header row naming a Key column, or else are DefaultInventorySchema.
+func (s *inventorySource) readCSV(ctx context.Context, location, schema string, escaped bool) error {
+	r, err := openInventoryFile(ctx, s.client, location)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	records := csv.NewReader(r)
+	records.FieldsPerRecord = -1
+
+	columns := map[string]int{}
+	setColumns := func(names []string) {
+		for i, name := range names {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+	}
+	if schema != "" {
+		setColumns(strings.Split(schema, ","))
+	}
+	for line := 1; ; line++ {
+		record, err := records.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			header := false
+			for _, name := range record {
+				header = header || strings.EqualFold(strings.TrimSpace(name), "Key")
+			}
+			if header {
+				setColumns(record)
+				continue
+			}
+			setColumns(strings.Split(DefaultInventorySchema, ","))
+		}
+		if _, ok := columns["size"]; !ok {
+			return fmt.Errorf("%v: inventory must include the object Size", location)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		object := minio.ObjectInfo{Key: field("key"), ETag: strings.Trim(field("etag"), `"`)}
+		if escaped {
+			if object.Key, err = url.QueryUnescape(object.Key); err != nil {
+				return fmt.Errorf("%v line %v: %w", location, line, err)
+			}
+		}
+		if bucket := field("bucket"); bucket != "" && bucket != sourceBucket {
+			continue
+		}
+		if !strings.HasPrefix(object.Key, sourcePrefix) || field("isdeletemarker") == "true" || field("islatest") == "false" {
+			continue
+		}
+		if object.Size, err = strconv.ParseInt(field("size"), 10, 64); err != nil {
+			return fmt.Errorf("%v line %v: %w", location, line, err)
+		}
+		if modified := field("lastmodifieddate"); modified != "" {
+			if object.LastModified, err = time.Parse(time.RFC3339, modified); err != nil {
+				return fmt.Errorf("%v line %v: %w", location, line, err)
+			}
+		}
+		s.objects = append(s.objects, object)
+	}
+}
+
+func (s *inventorySource) list(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		i := sort.Search(len(s.objects), func(i int) bool { return s.objects[i].Key > startAfter })
+		for _, object := range s.objects[i:] {
+			select {
+			case objects <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return objects
+}
+
+func (s *inventorySource) stat(ctx context.Context, key string) (minio.ObjectInfo, error) {
+	i := sort.Search(len(s.objects), func(i int) bool { return s.objects[i].Key >= key })
+	if i == len(s.objects) || s.objects[i].Key != key {
+		return minio.ObjectInfo{Key: key}, fmt.Errorf("object %v not found in inventory", key)
+	}
+	return s.objects[i], nil
+}
+
+// Open the object from offset, failing if it no longer matches the inventory
+func (s *inventorySource) open(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	object, err := s.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	opts := minio.GetObjectOptions{ServerSideEncryption: sourceEncryption}
+	if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, err
+		}
+	}
+	if object.ETag != "" {
+		if err := opts.SetMatchETag(object.ETag); err != nil {
+			return nil, err
+		}
+	} else {
+		info, err := s.client.StatObject(ctx, sourceBucket, key, minio.StatObjectOptions{ServerSideEncryption: sourceEncryption})
+		if err != nil {
+			return nil, err
+		}
+		if info.Size != object.Size {
+			return nil, fmt.Errorf("object %v size %v does not match inventory size %v", key, info.Size, object.Size)
+		}
+	}
+	obj, err := s.client.GetObject(ctx, sourceBucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Perform the request to check the precondition before any of the object is read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return nil, fmt.Errorf("object %v ETag does not match inventory ETag %v", key, object.ETag)
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *inventorySource) tags(ctx context.Context, key string) (map[string]string, error) {
+	return (&s3Source{client: s.client}).tags(ctx, key)
+}
+
+func (s *inventorySource) metadata(ctx context.Context, key string) (map[string]string, error) {
+	return (&s3Source{client: s.client}).metadata(ctx, key)
+}
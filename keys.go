@@ -0,0 +1,153 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// keyListSource reads the objects named in an explicit list of keys from another source, in list order
+type keyListSource struct {
+	source
+	keys []string
+}
+
+// Return the source of the keys read from the file name, or stdin when name is -
+func newKeyListSource(src source, name string) (*keyListSource, error) {
+	r := io.Reader(os.Stdin)
+	if name != StdoutOutput {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	keys, err := readKeys(r)
+	if err != nil {
+		return nil, err
+	}
+	return &keyListSource{source: src, keys: keys}, nil
+}
+
+// listedKey is a key given as a JSON object, as output by mc ls --json
+type listedKey struct {
+	Key string `json:"key"`
+}
+
+// Read a list of keys given either as a JSON array of keys or of objects with a key field,
+// or one per line, each a plain key or a JSON object with a key field. Blank lines are skipped.
+func readKeys(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(list))
+		for _, item := range list {
+			key, err := parseKey(item)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "{"):
+			key, err := parseKey([]byte(line))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		default:
+			keys = append(keys, line)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// Parse a key given as a JSON string or object with a key field
+func parseKey(item []byte) (string, error) {
+	var key string
+	if json.Unmarshal(item, &key) == nil {
+		return key, nil
+	}
+	var listed listedKey
+	if err := json.Unmarshal(item, &listed); err != nil {
+		return "", err
+	}
+	if listed.Key == "" {
+		return "", fmt.Errorf("missing key in %s", item)
+	}
+	return listed.Key, nil
+}
+
+// Send the listed keys in list order. As the list need not be in key order, startAfter resumes after
+// its first occurrence in the list.
+func (s *keyListSource) list(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		keys := s.keys
+		if startAfter != "" {
+			i := slices.Index(keys, startAfter)
+			if i < 0 {
+				objects <- minio.ObjectInfo{Key: startAfter, Err: fmt.Errorf("key %v to continue after is not in the key list", startAfter)}
+				return
+			}
+			keys = keys[i+1:]
+		}
+		for _, key := range keys {
+			object, err := s.stat(ctx, key)
+			if err != nil {
+				object.Key, object.Err = key, err
+			}
+			select {
+			case objects <- object:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return objects
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyListSourceStartAfter(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, key), []byte(key), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := &keyListSource{source: &fileSource{root: dir}, keys: []string{"c", "a", "b"}}
+	for _, tt := range []struct {
+		startAfter, want string
+		wantErr          bool
+	}{
+		{"", "c,a,b", false},
+		{"c", "a,b", false},
+		{"a", "b", false},
+		{"b", "", false},
+		{"d", "", true},
+	} {
+		var keys []string
+		var err error
+		for object := range src.list(context.Background(), tt.startAfter) {
+			if object.Err != nil {
+				err = object.Err
+				continue
+			}
+			keys = append(keys, object.Key)
+		}
+		if got := strings.Join(keys, ","); got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("list after %q = %q, %v, want %q, error %v", tt.startAfter, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
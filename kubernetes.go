@@ -0,0 +1,111 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultJobSpec is the job spec read in kubernetes mode, a config file mounted from a ConfigMap
+	DefaultJobSpec = "/etc/object-appender/job.yaml"
+	// DefaultTerminationLog is the file of the termination message read by Kubernetes when the container exits
+	DefaultTerminationLog = "/dev/termination-log"
+	// MaxTerminationMessage is the size of the termination message kept by Kubernetes
+	MaxTerminationMessage = 4096
+)
+
+// Exit statuses in kubernetes mode, telling the outcome of the run to the CronJob or operator. In any mode, a failed
+// run and invalid flags exit with ExitFailure, and flags that cannot be parsed exit with 2.
+const (
+	// ExitFailure is the exit status of a failed run
+	ExitFailure = 1
+	// ExitNoObjects is the exit status of a run finding no source objects to append
+	ExitNoObjects = 3
+	// ExitLocked is the exit status of a run refused as the run lock is held by another run
+	ExitLocked = 4
+)
+
+// Switch to kubernetes mode, logging JSON lines unless log-format is given, and return the config file to load,
+// the job spec unless config is given
+func startKubernetes(config string) string {
+	if logFormat == "" {
+		logFormat = JSONLogFormat
+	}
+	if config != "" {
+		return config
+	}
+	if _, err := os.Stat(DefaultJobSpec); err != nil {
+		return ""
+	}
+	return DefaultJobSpec
+}
+
+// Write the outcome of the run ending with err as the termination message in kubernetes mode
+func writeTermination(err error) {
+	if !kubernetes || terminationLog == "" {
+		return
+	}
+	summary.Lock()
+	message := struct {
+		RunID    string   `json:"runId"`
+		Targets  []string `json:"targets"`
+		Objects  int      `json:"objects"`
+		Bytes    int64    `json:"bytes"`
+		Duration float64  `json:"duration"`
+		Error    string   `json:"error,omitempty"`
+	}{runID, summary.Targets, len(summary.Matched), summary.Bytes, time.Since(runStart).Seconds(), ""}
+	summary.Unlock()
+	if err != nil {
+		message.Error = err.Error()
+	}
+	data, err := json.Marshal(message)
+	if err == nil && len(data) > MaxTerminationMessage {
+		// Too many resulting objects to list
+		message.Targets = message.Targets[:1]
+		data, err = json.Marshal(message)
+	}
+	if err == nil {
+		err = os.WriteFile(terminationLog, data, 0o644)
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to write termination message %v - %v", terminationLog, err), "file", terminationLog, "error", err.Error())
+	}
+}
+
+// Return the exit status of the run ending with err, telling apart failures in kubernetes mode
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errInputsUnchanged):
+		return unchangedExitCode
+	case !kubernetes:
+		return ExitFailure
+	case errors.Is(err, errNoObjects):
+		return ExitNoObjects
+	case errors.Is(err, errLeaseHeld):
+		return ExitLocked
+	}
+	return ExitFailure
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	defer func(k bool, unchanged int) { kubernetes, unchangedExitCode = k, unchanged }(kubernetes, unchangedExitCode)
+	unchangedExitCode = 5
+	failed := errors.New("failed")
+	for _, tt := range []struct {
+		kubernetes bool
+		err        error
+		want       int
+	}{
+		{false, nil, 0},
+		{false, errInputsUnchanged, 5},
+		{false, failed, ExitFailure},
+		{false, errNoObjects, ExitFailure},
+		{false, fmt.Errorf("%w: run elsewhere", errLeaseHeld), ExitFailure},
+		{true, nil, 0},
+		{true, errInputsUnchanged, 5},
+		{true, failed, ExitFailure},
+		{true, errNoObjects, ExitNoObjects},
+		{true, fmt.Errorf("%w: run elsewhere", errLeaseHeld), ExitLocked},
+	} {
+		kubernetes = tt.kubernetes
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) with kubernetes %v = %v, want %v", tt.err, tt.kubernetes, got, tt.want)
+		}
+	}
+}
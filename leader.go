@@ -0,0 +1,75 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// LeaderName is the name of the state object leased by the replica elected leader
+const LeaderName = "leader.json"
+
+// Run the daemon as the leader of the replicas started with leader-election, standing by until this replica is
+// elected. Should another replica take over the lease, run is canceled and this replica stands by again.
+func lead(ctx context.Context, target sink, run func(ctx context.Context)) {
+	if !leaderElection {
+		run(ctx)
+		return
+	}
+	// The replica holds the lease across its runs, which each get their own run ID
+	name, holder := stateObjectName(LeaderName), runID
+	standing := false
+	for ctx.Err() == nil {
+		err := takeLease(ctx, target, name, holder)
+		if err == nil {
+			log.Println("Elected leader")
+			standing = false
+			leaderCtx, cancel := context.WithCancel(ctx)
+			release := keepLease(ctx, target, name, holder, func() {
+				slog.Warn("Lost leadership to another replica")
+				cancel()
+			})
+			run(leaderCtx)
+			deposed := leaderCtx.Err() != nil
+			cancel()
+			release()
+			if !deposed {
+				// The daemon stopped on its own
+				return
+			}
+			continue
+		}
+		if !errors.Is(err, errLeaseHeld) {
+			slog.Error(fmt.Sprintf("Failed to take lease %v - %v", name, err), "object", name, "error", err.Error())
+		} else if !standing {
+			log.Printf("Standing by - %v\n", err)
+			standing = true
+		}
+		timer := time.NewTimer(lockTTL / 3)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
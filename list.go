@@ -0,0 +1,156 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"log"
+	"time"
+)
+
+var errNoObjects = errors.New("no objects found")
+
+// List the source objects to append, in listing order, starting after startAfter when set.
+// Objects not selected by selectObject are skipped; a listing error is sent as an object with Err set.
+// Listing stops once max-objects or max-bytes is reached, recording the last object listed in cappedAfter.
+// Unless counted, as when the objects are only hashed, the listing is not counted, recorded or logged.
+func listSourceObjects(ctx context.Context, src source, startAfter string, counted bool) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		if startAfter == "" {
+			// Continue after the objects appended by the previous capped run
+			startAfter = watermark.Key
+		}
+		var count, size int64
+		var last string
+		start := time.Now()
+		_, span := startSpan(ctx, "list", "prefix", sourcePrefix)
+		var listErr error
+		defer func() {
+			observe("list", time.Since(start))
+			span.end(listErr)
+		}()
+		for object := range src.list(ctx, startAfter) {
+			if object.Err != nil {
+				listErr = object.Err
+			} else {
+				if counted {
+					objectsListed.Add(1)
+				}
+				selected, err := selectObject(ctx, src, object, counted)
+				if err != nil {
+					object.Err = err
+				} else if !selected {
+					continue
+				}
+				if capReached(count, size, object) {
+					if counted {
+						log.Printf("Reached max-objects or max-bytes, leaving objects from %v to the next run", object.Key)
+						cappedAfter = last
+					}
+					return
+				}
+			}
+			select {
+			case objects <- object:
+			case <-ctx.Done():
+				return
+			}
+			count, size, last = count+1, size+object.Size, object.Key
+		}
+		if emptyCount > 0 && counted {
+			log.Printf("Skipped empty objects: %v", emptyCount)
+		}
+	}()
+	return objects
+}
+
+// Return whether appending the object would exceed max-objects or max-bytes, after count objects of size bytes.
+// The first object is appended regardless of its size, so that a run always progresses.
+func capReached(count, size int64, object minio.ObjectInfo) bool {
+	if maxObjects > 0 && uint64(count) >= maxObjects {
+		return true
+	}
+	return maxBytes > 0 && count > 0 && uint64(size+object.Size) > maxBytes
+}
+
+// Return whether the source object should be appended, fetching its tags when filtering by tags, and its
+// metadata when filtering by metadata and the listing did not include it. Objects skipped are recorded if counted.
+func selectObject(ctx context.Context, src source, object minio.ObjectInfo, counted bool) (bool, error) {
+	skip := func(reason string) (bool, error) {
+		if counted {
+			recordSkip(object.Key, reason)
+		}
+		return false, nil
+	}
+	if reason := skipReason(object); reason != "" {
+		return skip(reason)
+	}
+	if len(metadataFilters) > 0 {
+		metadata, err := objectMetadata(ctx, src, object)
+		if err != nil {
+			return false, err
+		}
+		if !matchMetadata(metadata) {
+			return skip("metadata-filter")
+		}
+	}
+	if len(tagFilters) > 0 {
+		tags, err := src.tags(ctx, object.Key)
+		if err != nil {
+			return false, err
+		}
+		if !matchTags(tags) {
+			return skip("tag-filter")
+		}
+	}
+	if skipEmpty && object.Size == 0 {
+		if counted {
+			log.Printf("Skipping empty object: %v", object.Key)
+			emptyCount++
+		}
+		return skip("skip-empty")
+	}
+	return true, nil
+}
+
+// Return the filter not selecting the listed source object, or empty if it should be appended
+func skipReason(object minio.ObjectInfo) string {
+	switch {
+	case !matchKey(object.Key):
+		return "key"
+	case !ownedByWorker(object.Key):
+		return "worker"
+	case uint64(object.Size) < minSize || (maxSize > 0 && uint64(object.Size) > maxSize):
+		return "size"
+	case !modifiedAfter.IsZero() && !object.LastModified.After(modifiedAfter):
+		return "modified-after"
+	case !modifiedBefore.IsZero() && !object.LastModified.Before(modifiedBefore):
+		return "modified-before"
+	case newerThan > 0 && !object.LastModified.After(runStart.Add(-newerThan)):
+		return "newer-than"
+	case olderThan > 0 && object.LastModified.After(runStart.Add(-olderThan)):
+		return "older-than"
+	case incremental && watermark.covers(object):
+		return "incremental"
+	}
+	return ""
+}
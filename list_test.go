@@ -0,0 +1,49 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/minio/minio-go/v7"
+	"testing"
+)
+
+func TestCapReached(t *testing.T) {
+	defer func(objects, bytes uint64) { maxObjects, maxBytes = objects, bytes }(maxObjects, maxBytes)
+	for _, tt := range []struct {
+		maxObjects, maxBytes uint64
+		count, size          int64
+		object               int64
+		want                 bool
+	}{
+		{0, 0, 1000, 1 << 40, 1 << 40, false},
+		{2, 0, 1, 10, 10, false},
+		{2, 0, 2, 20, 10, true},
+		{0, 10, 0, 0, 100, false},
+		{0, 10, 1, 5, 5, false},
+		{0, 10, 1, 5, 6, true},
+		{0, 10, 1, 10, 0, false},
+		{3, 10, 2, 5, 6, true},
+		{3, 100, 3, 5, 6, true},
+	} {
+		maxObjects, maxBytes = tt.maxObjects, tt.maxBytes
+		if got := capReached(tt.count, tt.size, minio.ObjectInfo{Size: tt.object}); got != tt.want {
+			t.Errorf("max-objects %v, max-bytes %v: capReached(%v, %v, %v bytes) = %v, want %v",
+				tt.maxObjects, tt.maxBytes, tt.count, tt.size, tt.object, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// LockName is the name of the state object leased by the run holding the run lock
+const LockName = "lock.json"
+
+var (
+	errLeaseHeld = errors.New("lease held by another run")
+	errLockLost  = errors.New("run lock lost to another run")
+)
+
+// lockState is a lease, held by a run until it expires unless renewed
+type lockState struct {
+	RunID   string    `json:"runId"`
+	Host    string    `json:"host"`
+	Expires time.Time `json:"expires"`
+}
+
+// Return a lease held by holder until lock-ttl from now
+func newLease(holder string) ([]byte, error) {
+	host, _ := os.Hostname()
+	return json.Marshal(lockState{RunID: holder, Host: host, Expires: time.Now().Add(lockTTL).UTC()})
+}
+
+// Return the lease of the state object name with its version, or nil if it is not held
+func loadLease(ctx context.Context, target sink, name string) (*lockState, string, error) {
+	data, version, err := target.getStateVersion(ctx, name)
+	if err != nil || data == nil {
+		return nil, "", err
+	}
+	var l lockState
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, "", err
+	}
+	return &l, version, nil
+}
+
+// Take the lease of the state object name for holder with a conditional write, taking over a lease that expired
+// by replacing the version of it read, so that only one of the runs seeing it expired takes it over.
+// Fails with errLeaseHeld while another run holds it.
+func takeLease(ctx context.Context, target sink, name, holder string) error {
+	lease, err := newLease(holder)
+	if err != nil {
+		return err
+	}
+	created, err := target.createState(ctx, name, lease)
+	if err != nil || created {
+		return err
+	}
+	held, version, err := loadLease(ctx, target, name)
+	if err != nil {
+		return err
+	}
+	if held == nil {
+		return fmt.Errorf("%w: released while taking it", errLeaseHeld)
+	}
+	if time.Now().Before(held.Expires) {
+		return fmt.Errorf("%w: run %v on %v until %v", errLeaseHeld, held.RunID, held.Host, held.Expires)
+	}
+	slog.Warn(fmt.Sprintf("Taking over %v of run %v, expired at %v", name, held.RunID, held.Expires), "object", name, "holder", held.RunID, "expires", held.Expires)
+	replaced, err := target.replaceState(ctx, name, lease, version)
+	if err != nil {
+		return err
+	}
+	if !replaced {
+		return fmt.Errorf("%w: taken over by another run since run %v expired", errLeaseHeld, held.RunID)
+	}
+	return nil
+}
+
+// Renew the lease of the state object name held by holder well before it expires, replacing only the version
+// of it read, and calling lost should another run have taken it over or should it expire before being renewed.
+// The lease is released by the returned function.
+func keepLease(ctx context.Context, target sink, name, holder string, lost func()) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		expires := time.Now().Add(lockTTL)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			renewed := time.Now()
+			held, version, err := loadLease(ctx, target, name)
+			if err == nil && (held == nil || held.RunID != holder) {
+				lost()
+				return
+			}
+			var lease []byte
+			if err == nil {
+				lease, err = newLease(holder)
+			}
+			replaced := false
+			if err == nil {
+				replaced, err = target.replaceState(ctx, name, lease, version)
+			}
+			if err == nil && !replaced {
+				// Taken over since it was read
+				lost()
+				return
+			}
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Failed to renew %v - %v", name, err), "object", name, "error", err.Error())
+				if time.Now().After(expires) {
+					lost()
+					return
+				}
+				continue
+			}
+			expires = renewed.Add(lockTTL)
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		// Leave a lease taken over since, which only happens once ours expired
+		ctx := context.Background()
+		if held, _, err := loadLease(ctx, target, name); err != nil || held == nil || held.RunID != holder {
+			return
+		}
+		if err := target.removeState(ctx, name); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to release %v - %v", name, err), "object", name, "error", err.Error())
+		}
+	}
+}
+
+// Acquire the run lock, so that overlapping runs do not append the same source objects twice. The lock is held
+// until the returned function releases it. Should it be lost, the returned context is canceled with errLockLost.
+func acquireLock(ctx context.Context, target sink) (context.Context, func(), error) {
+	if !lock {
+		return ctx, func() {}, nil
+	}
+	name := stateObjectName(LockName)
+	if err := takeLease(ctx, target, name, runID); err != nil {
+		slog.Error(fmt.Sprintf("Failed to acquire lock %v - %v", name, err), "object", name, "error", err.Error())
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	release := keepLease(ctx, target, name, runID, func() {
+		slog.Error(fmt.Sprintf("Lost lock %v to another run, stopping", name), "object", name)
+		cancel(errLockLost)
+	})
+	return ctx, func() {
+		release()
+		cancel(nil)
+	}, nil
+}
+
+// Return errLockLost if the run failed as its lock was lost, otherwise err
+func lockError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); err != nil && errors.Is(cause, errLockLost) {
+		return cause
+	}
+	return err
+}
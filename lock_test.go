@@ -0,0 +1,167 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTakeLease(t *testing.T) {
+	defer func(ttl time.Duration) { lockTTL = ttl }(lockTTL)
+	lockTTL = time.Minute
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name    string
+		held    *lockState
+		wantErr error
+		holder  string
+	}{
+		{"free", nil, nil, "b"},
+		{"held", &lockState{RunID: "a", Expires: time.Now().Add(time.Minute)}, errLeaseHeld, "a"},
+		{"expired", &lockState{RunID: "a", Expires: time.Now().Add(-time.Second)}, nil, "b"},
+	} {
+		target := &fileSink{dir: t.TempDir()}
+		if tt.held != nil {
+			data, err := json.Marshal(tt.held)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := target.putState(ctx, LockName, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := takeLease(ctx, target, LockName, "b"); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: takeLease = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		held, _, err := loadLease(ctx, target, LockName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if held == nil || held.RunID != tt.holder {
+			t.Errorf("%v: lease held by %+v, want %v", tt.name, held, tt.holder)
+			continue
+		}
+		if tt.wantErr == nil && !held.Expires.After(time.Now().Add(lockTTL/2)) {
+			t.Errorf("%v: lease taken until %v, want lock-ttl from now", tt.name, held.Expires)
+		}
+	}
+}
+
+func TestTakeLeaseExpiredOnce(t *testing.T) {
+	defer func(ttl time.Duration) { lockTTL = ttl }(lockTTL)
+	lockTTL = time.Minute
+	ctx := context.Background()
+	target := &fileSink{dir: t.TempDir()}
+	data, err := json.Marshal(lockState{RunID: "a", Expires: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := target.putState(ctx, LockName, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Runs seeing the same expired lease race to take it over
+	errs := make(chan error)
+	for _, holder := range []string{"b", "c", "d", "e", "f", "g", "h", "i"} {
+		go func(holder string) { errs <- takeLease(ctx, target, LockName, holder) }(holder)
+	}
+	taken := 0
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err == nil {
+			taken++
+		} else if !errors.Is(err, errLeaseHeld) {
+			t.Error(err)
+		}
+	}
+	if taken != 1 {
+		t.Errorf("lease taken over %v times, want once", taken)
+	}
+}
+
+func TestReplaceStateStaleVersion(t *testing.T) {
+	ctx := context.Background()
+	target := &fileSink{dir: t.TempDir()}
+	if err := target.putState(ctx, LockName, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := target.getStateVersion(ctx, LockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced, err := target.replaceState(ctx, LockName, []byte("b"), version); err != nil || !replaced {
+		t.Fatalf("replaceState = %v, %v, want replaced", replaced, err)
+	}
+	if replaced, err := target.replaceState(ctx, LockName, []byte("c"), version); err != nil || replaced {
+		t.Fatalf("replaceState of a stale version = %v, %v, want not replaced", replaced, err)
+	}
+	if data, _ := target.getState(ctx, LockName); string(data) != "b" {
+		t.Errorf("state = %q, want b", data)
+	}
+}
+
+func TestKeepLease(t *testing.T) {
+	defer func(ttl time.Duration) { lockTTL = ttl }(lockTTL)
+	lockTTL = 300 * time.Millisecond
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name     string
+		takeOver bool
+		wantLost bool
+	}{
+		{"renewed", false, false},
+		{"taken over", true, true},
+	} {
+		target := &fileSink{dir: t.TempDir()}
+		if err := takeLease(ctx, target, LockName, "a"); err != nil {
+			t.Fatal(err)
+		}
+		lost := make(chan struct{})
+		release := keepLease(ctx, target, LockName, "a", func() { close(lost) })
+		if tt.takeOver {
+			lease, err := newLease("b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := target.putState(ctx, LockName, lease); err != nil {
+				t.Fatal(err)
+			}
+		}
+		select {
+		case <-lost:
+			if !tt.wantLost {
+				t.Errorf("%v: lease lost", tt.name)
+			}
+		case <-time.After(3 * lockTTL):
+			if tt.wantLost {
+				t.Errorf("%v: lease not lost", tt.name)
+			}
+			held, _, err := loadLease(ctx, target, LockName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if held == nil || held.RunID != "a" || !held.Expires.After(time.Now()) {
+				t.Errorf("%v: lease is %+v, want renewed by a", tt.name, held)
+			}
+		}
+		release()
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// log-format values
+const (
+	// TextLogFormat logs key=value lines
+	TextLogFormat = "text"
+	// JSONLogFormat logs JSON lines
+	JSONLogFormat = "json"
+)
+
+// LogLevels maps the log-level values to their level
+var LogLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// levelVar is the level of the messages logged, settable once the flags are parsed
+var levelVar slog.LevelVar
+
+// classicHandler logs the message of each record as the log package does, prefixed with its time and run ID.
+// The attributes of the record are left to the structured log formats.
+type classicHandler struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (h classicHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= levelVar.Level()
+}
+
+func (h classicHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.w, "%s %s %s\n", r.Time.Format("2006/01/02 15:04:05"), runID, r.Message)
+	return err
+}
+
+func (h classicHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h classicHandler) WithGroup(name string) slog.Handler       { return h }
+
+// runHandler adds the run ID, which changes between runs, to every record
+type runHandler struct {
+	slog.Handler
+}
+
+func (h runHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(slog.String("run_id", runID))
+	return h.Handler.Handle(ctx, r)
+}
+
+// logWriter logs the lines of the log package as records at the info level. Failures and warnings are logged
+// through slog, with their attributes.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	slog.Info(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Log the reason the run cannot start at the error level, then exit
+func fatal(v ...interface{}) {
+	slog.Error(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+	os.Exit(ExitFailure)
+}
+
+// Log with the log-format at the log-level, the lines of the log package included. The classic format, without
+// log-format, logs the lines as they always were.
+func configureLogging() error {
+	level, ok := LogLevels[logLevel]
+	if !ok {
+		return errors.New("log-level must be debug, info, warn or error")
+	}
+	if quiet && verbose {
+		return errors.New("q and v cannot be combined")
+	}
+	if verbose {
+		level = slog.LevelDebug
+	}
+	levelVar.Set(level)
+	opts := &slog.HandlerOptions{Level: &levelVar}
+	var handler slog.Handler
+	switch logFormat {
+	case "":
+		handler = classicHandler{mu: &sync.Mutex{}, w: os.Stderr}
+	case TextLogFormat:
+		handler = runHandler{slog.NewTextHandler(os.Stderr, opts)}
+	case JSONLogFormat:
+		handler = runHandler{slog.NewJSONHandler(os.Stderr, opts)}
+	default:
+		return errors.New("log-format must be text or json")
+	}
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(logWriter{})
+	return nil
+}
@@ -18,95 +18,965 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/robfig/cron/v3"
 	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// BucketLookupStyles maps the lookup-style values to the bucket addressing styles of the client
+var BucketLookupStyles = map[string]minio.BucketLookupType{
+	"auto": minio.BucketLookupAuto,
+	"dns":  minio.BucketLookupDNS,
+	"path": minio.BucketLookupPath,
+}
+
 // Variables configured at program start from program parameters and other inputs
 var (
 	sourceBucket, sourcePrefix, sourceBucketPrefix string
 	targetBucket, targetPrefix, targetBucketPrefix string
-	endpoint, accessKey, secretKey                 string
-	enableCleanUp                                  string
+	endpoint, accessKey, secretKey, sessionToken   string
+	sourceConnection, targetConnection             connection
+	secure, noTLS, insecure, noVerify              bool
+	caCert, clientCert, clientKey                  string
+	proxy                                          string
+	output                                         string
+	targetNameText                                 string
+	nameByHash                                     bool
+	timestampFormat, timestampTimezone             string
+	ifNoneMatch                                    string
+	appendTo                                       string
+	rotateSize                                     uint64
+	rotateInterval                                 time.Duration
+	shards                                         uint
+	shardBy                                        string
+	sse, sseKMSKeyID                               string
+	sourceSSECKey, targetSSECKey                   string
+	encryptRecipients                              stringList
+	encryptRecipientsFile                          string
+	preserveMetadataMode                           string
+	targetMetadataList, targetTagList              stringList
+	storageClass, contentTypeOverride              string
+	checksumAlgorithm                              string
+	retentionMode, retentionUntil                  string
+	legalHold, outputChecksum, outputCRC32C        bool
+	writeManifest, provenance, yes, staging        bool
+	diffName, outputJSON                           string
+	skipUnchanged, lock                            bool
+	lockTTL                                        time.Duration
+	workerIndex, workerCount                       uint
+	leaderElection, kubernetes                     bool
+	terminationLog                                 string
+	httpAddr, pushgatewayURL, statsdAddr           string
+	notifyURL, notifySecret                        string
+	publishList                                    stringList
+	alertEmail, smtpHost, smtpUser, smtpPassword   string
+	smtpFrom                                       string
+	smtpPort                                       int
+	unchangedExitCode                              int
+	indexFormat                                    string
+	keysFrom                                       string
+	inventory                                      string
+	includes, excludes                             stringList
+	keyRegex                                       string
+	minSize, maxSize                               uint64
+	tagFilterList                                  stringList
+	metadataFilterList                             stringList
+	skipEmpty                                      bool
+	maxObjects, maxBytes                           uint64
+	order                                          string
+	separator                                      string
+	objectHeaderText, objectTrailerText            string
+	ensureNewline                                  bool
+	framing                                        string
+	format                                         string
+	compress                                       string
+	compressLevel                                  int
+	decompressSources                              string
+	stripBOM                                       bool
+	normalizeNewlines                              string
+	modifiedAfter, modifiedBefore                  time.Time
+	newerThan, olderThan                           time.Duration
+	region                                         string
+	lookupStyle                                    string
+	anonymous                                      bool
+	signature                                      string
+	alias, mcConfigDir                             string
+	credentialChain                                bool
+	roleARN, roleSessionName, stsEndpoint          string
+	webIdentityTokenFile                           string
+	enableCleanUp, trashPrefix                     string
+	logLevel, logFormat                            string
+	quiet, verbose, audit                          bool
+	trashTTL                                       time.Duration
+	serverSide                                     bool
+	partSize                                       uint64
+	uploadConcurrency                              uint
+	downloadConcurrency                            uint
+	resume                                         bool
+	incremental                                    bool
+	watch                                          bool
+	flushSize                                      uint64
+	flushInterval                                  time.Duration
+	schedule                                       string
+	scheduleJitter                                 time.Duration
+	cronSchedule                                   cron.Schedule
 
-	buffer           *bytes.Buffer
 	targetObjectName string
+	runID            string
+	runStart         time.Time
+	cappedAfter      string
+	targetSeq        int
+	targetShard      int
 
 	// Debug
 	objectCount, objectSize int64
+	emptyCount              int64
 )
 
 const (
 	// ContentType is defaulted to application/octet-stream for this demo
 	ContentType = "application/octet-stream"
-	// TimeFormat is the human-readable format used for file naming
+	// TimeFormat is the default human-readable format used for file naming
 	TimeFormat = "20060102150405"
+	// DefaultFlushSize is the default amount of pending source objects that triggers a flush in watch mode
+	DefaultFlushSize = "128MiB"
+	// DefaultPartSize is the default size of each part streamed to the target, bounding the resulting object to 640 GiB
+	DefaultPartSize = "64MiB"
 )
 
 func main() {
+	setRunID(uuid.NewString())
+	verifying := len(os.Args) > 1 && os.Args[1] == VerifyCommand
+	if verifying {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	flag.StringVar(&sourceBucketPrefix, "source-bucket-prefix", "", "s3 source containing miscellaneous objects")
 	flag.StringVar(&targetBucketPrefix, "target-bucket-prefix", "", "s3 target receiving single resulting object")
+	flag.StringVar(&keysFrom, "keys-from", "", "file (or - for stdin) listing the source object keys to append in order, one per line or as JSON, instead of listing source-bucket-prefix")
+	flag.StringVar(&inventory, "inventory-manifest", "", "S3 Inventory manifest.json, or inventory CSV, as bucket/key or file:///path listing the source objects instead of listing source-bucket-prefix")
+	flag.Var(&includes, "include", "only append objects whose key matches the glob pattern, in which * also matches /, may be repeated")
+	flag.Var(&excludes, "exclude", "do not append objects whose key matches the glob pattern, may be repeated")
+	flag.StringVar(&keyRegex, "key-regex", "", "only append objects whose key matches the regular expression")
+	var minSizeString, maxSizeString string
+	flag.StringVar(&minSizeString, "min-size", "", "only append objects of at least this size, e.g. 1KiB")
+	flag.StringVar(&maxSizeString, "max-size", "", "only append objects of at most this size, e.g. 1GiB")
+	var modifiedAfterString, modifiedBeforeString string
+	flag.StringVar(&modifiedAfterString, "modified-after", "", "only append objects modified after this RFC3339 time or date, e.g. 2024-02-26")
+	flag.StringVar(&modifiedBeforeString, "modified-before", "", "only append objects modified before this RFC3339 time or date")
+	flag.DurationVar(&newerThan, "newer-than", 0, "only append objects modified within this duration before the run, e.g. 24h")
+	flag.DurationVar(&olderThan, "older-than", 0, "only append objects modified at least this duration before the run")
+	flag.Var(&tagFilterList, "tag-filter", "only append objects tagged with key=value, may be repeated to require several tags")
+	flag.Var(&metadataFilterList, "metadata-filter", "only append objects with user metadata name=value, e.g. X-Amz-Meta-App=foo, may be repeated")
+	flag.BoolVar(&skipEmpty, "skip-empty", false, "do not append zero-byte objects, such as directory markers")
+	var maxBytesString, rotateSizeString string
+	flag.Uint64Var(&maxObjects, "max-objects", 0, "maximum number of objects appended by a run, leaving the remaining objects to the next incremental run")
+	flag.StringVar(&maxBytesString, "max-bytes", "", "maximum amount of objects appended by a run, e.g. 10GiB, leaving the remaining objects to the next incremental run")
+	flag.StringVar(&order, "order", "", "order in which objects are appended: key-asc, key-desc, mtime-asc or natural, sorting a snapshot of the listing, or manifest, the order of keys-from (default listing order)")
+	flag.StringVar(&separator, "separator", "", "written between source objects, with escapes such as \\n or \\x1e")
+	flag.StringVar(&objectHeaderText, "object-header", "", "template written before each source object, e.g. \"== {{.Key}} ({{.Size}} bytes, {{.LastModified}}) ==\\n\"")
+	flag.StringVar(&objectTrailerText, "object-trailer", "", "template written after each source object")
+	flag.BoolVar(&ensureNewline, "ensure-newline", false, "add a newline to source objects not ending with one")
+	flag.StringVar(&framing, "framing", "", "length-prefixed to write each source object after a header of its key and length")
+	flag.StringVar(&format, "format", "", "tar or zip to write the source objects as entries of an archive, gzip-members to concatenate gzip source objects into a multi-member gzip, ndjson to write each source object as a line of JSON, csv-merge to merge CSV source objects under a single header row, json-array to merge JSON source objects into a single array, or parquet or avro to merge Parquet or Avro source objects sharing a schema")
+	flag.StringVar(&compress, "compress", "", "gzip, zstd, lz4 or snappy to compress the resulting object")
+	flag.IntVar(&compressLevel, "compress-level", DefaultCompressLevel, "compression level, 1 (fastest) to 9 (best) for gzip and lz4, or to 22 for zstd (default the codec default)")
+	flag.StringVar(&decompressSources, "decompress-sources", "", "auto to decompress gzip, zstd and bzip2 source objects while appending them")
+	flag.BoolVar(&stripBOM, "strip-bom", false, "remove the UTF-8 byte order mark starting source objects")
+	flag.StringVar(&normalizeNewlines, "normalize-newlines", "", "lf or crlf to normalize the newlines of source objects")
+	flag.StringVar(&targetNameText, "target-name-template", DefaultTargetNameTemplate, "template of the resulting object name under target-bucket-prefix, from {{.SourceBucket}}, {{.SourcePrefix}}, {{.RunID}}, {{.Date \"2006/01/02\"}}, {{.ObjectCount}} and the key-regex groups {{.Groups.name}} of the first object appended, followed by the output extension")
+	flag.StringVar(&timestampFormat, "timestamp-format", TimeFormat, "Go layout of the time of the run in the resulting object name, e.g. 2006-01-02T15-04-05")
+	flag.StringVar(&timestampTimezone, "timestamp-timezone", "UTC", "timezone of the time of the run in the resulting object name, e.g. Local or Europe/Paris")
+	flag.BoolVar(&nameByHash, "name-by-hash", false, "name the resulting object after the SHA-256 of its contents, as the target-name-template {{.SHA256}}")
+	flag.StringVar(&ifNoneMatch, "if-none-match", "", "fail to fail the run, or suffix to add a numeric suffix to the name, when the resulting object already exists")
+	flag.StringVar(&appendTo, "append-to", "", "existing object under target-bucket-prefix extended with the appended objects, composed on the server, instead of a new resulting object")
+	flag.StringVar(&rotateSizeString, "rotate-size", "", "size, e.g. 1GiB, after which a run completes its resulting object and continues in a new one, numbered -0001, -0002..., never splitting a source object")
+	flag.UintVar(&shards, "shards", 1, "number of resulting objects, numbered -0001-of-N..., across which the source objects are distributed for parallel consumption")
+	flag.StringVar(&shardBy, "shard-by", RoundRobinShards, "distribution of the source objects across shards: round-robin, or key-hash so that a key always lands in the same shard")
+	flag.StringVar(&sse, "sse", "", "server-side encryption of the resulting object: s3 (SSE-S3) or kms (SSE-KMS)")
+	flag.StringVar(&sseKMSKeyID, "sse-kms-key-id", "", "KMS key ID with which the resulting object is encrypted, implying sse kms (default the default key of the KMS)")
+	flag.StringVar(&sourceSSECKey, "source-sse-c-key", "", "base64-encoded 256-bit key of the source objects encrypted with SSE-C")
+	flag.StringVar(&targetSSECKey, "target-sse-c-key", "", "base64-encoded 256-bit key with which the resulting object is encrypted with SSE-C")
+	flag.Var(&encryptRecipients, "encrypt-recipient", "age public key (age1...) to which the resulting object is encrypted client-side before upload, may be repeated")
+	flag.StringVar(&encryptRecipientsFile, "encrypt-recipients-file", "", "file of age public keys, one per line, to which the resulting object is encrypted client-side before upload")
+	flag.StringVar(&preserveMetadataMode, "preserve-metadata", "", "first to store the user metadata of the first source object on the resulting object, or merge to store that of every source object, joining distinct values with commas")
+	flag.Var(&targetMetadataList, "target-metadata", "user metadata name=value of the resulting object, may be repeated")
+	flag.Var(&targetTagList, "target-tag", "tag key=value of the resulting object, e.g. for lifecycle rules, may be repeated")
+	flag.StringVar(&storageClass, "storage-class", "", "storage class of the resulting object, e.g. STANDARD, REDUCED_REDUNDANCY or a MinIO tier name, or the access tier on azure, e.g. Cool")
+	flag.StringVar(&retentionMode, "retention-mode", "", "Object Lock retention mode of the resulting object: GOVERNANCE or COMPLIANCE")
+	flag.StringVar(&retentionUntil, "retention-until", "", "time until which the resulting object is retained, in RFC 3339 or as a period from when it is written, e.g. 720h")
+	flag.StringVar(&checksumAlgorithm, "checksum-algorithm", "", "checksum the s3 server validates each part of the resulting object uploaded against: CRC32C or SHA256")
+	flag.BoolVar(&outputChecksum, "output-checksum", false, "compute the SHA-256 of the resulting object, storing it as its "+SHA256Metadata+" metadata, and verify each part uploaded to s3 against its ETag")
+	flag.BoolVar(&outputCRC32C, "output-crc32c", false, "also compute the CRC32C of the resulting object, storing it as its "+CRC32CMetadata+" metadata, implying output-checksum")
+	flag.BoolVar(&legalHold, "legal-hold", false, "place the resulting object under an Object Lock legal hold")
+	flag.StringVar(&contentTypeOverride, "content-type", "", "content type of the resulting object (default that of the format, or detected from the source objects)")
+	flag.BoolVar(&writeManifest, "manifest", false, "write the source objects appended to the resulting object, with their offset and length within it, as the sidecar object <target>"+ManifestExtension)
+	flag.StringVar(&indexFormat, "index", "", "write the byte-range index of the source objects within the resulting object as the sidecar object <target>.index.csv (csv) or <target>.index (binary)")
+	flag.BoolVar(&provenance, "provenance", false, "record the source bucket and prefix, object count, size, version and run time as Object-Appender-* metadata of the resulting object")
+	flag.StringVar(&diffName, "diff", "", "report the source objects added, removed and changed since the manifest of this resulting object under target-bucket-prefix was written, without appending")
+	flag.StringVar(&outputJSON, "output-json", "", "write a JSON summary of each run, with the keys appended and skipped, the resulting objects, bytes, duration and errors, to this file or - for stdout")
+	flag.BoolVar(&yes, "yes", false, "do not ask for confirmation of destructive actions, such as overwriting or rewriting existing objects")
+	flag.BoolVar(&staging, "staging", false, "upload the resulting object under "+StagingPrefix+"/ of the target prefix, putting it in place once verified, and remove staged objects abandoned for a day")
+	flag.BoolVar(&skipUnchanged, "skip-unchanged", false, "skip the run when the keys, ETags and sizes of the source objects to append are those of the previous successful run, so that retried jobs do not write duplicate resulting objects")
+	flag.IntVar(&unchangedExitCode, "unchanged-exit-code", 0, "exit status of a run skipped with skip-unchanged")
+	flag.BoolVar(&lock, "lock", false, "hold a lock leased in the target for lock-ttl while running, so that overlapping runs, e.g. of cron jobs, fail instead of appending the same source objects twice")
+	flag.DurationVar(&lockTTL, "lock-ttl", time.Hour, "duration of the lease of the lock, renewed while running, after which the lock of a crashed run is taken over")
+	flag.UintVar(&workerIndex, "worker-index", 0, "index, from 0, of this instance among worker-count instances splitting the source objects by the hash of their keys")
+	flag.UintVar(&workerCount, "worker-count", 1, "number of instances splitting the source objects, each writing its own resulting objects suffixed -worker-<index>")
+	flag.BoolVar(&leaderElection, "leader-election", false, "elect a leader among the replicas running with watch or schedule through a lease in the target renewed every lock-ttl/3, the other replicas standing by until the lease expires")
+	flag.BoolVar(&kubernetes, "kubernetes", false, "run as a Kubernetes CronJob or operator job: read the job spec "+DefaultJobSpec+" unless config is given, log JSON lines, write a termination message and exit with distinct statuses")
+	flag.StringVar(&terminationLog, "termination-log", DefaultTerminationLog, "file receiving the termination message in kubernetes mode")
+	flag.StringVar(&httpAddr, "http-addr", "", "address, e.g. :8080, serving /metrics, /healthz, and /readyz once the source is listed and the target reached, in watch and schedule modes")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway, e.g. http://pushgateway:9091, receiving the metrics of a one-shot run when it exits")
+	flag.StringVar(&statsdAddr, "statsd-addr", "", "StatsD or Datadog agent, e.g. localhost:8125, receiving the counters of each run and the latencies of its operations, tagged DogStatsD style")
+	flag.StringVar(&logLevel, "log-level", "info", "level of the messages logged: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", "", "structured format of the messages logged, with fields such as key, bytes, duration and error: text (key=value) or json")
+	flag.BoolVar(&quiet, "q", false, "quiet: do not log the Obtaining line of each source object")
+	flag.BoolVar(&verbose, "v", false, "verbose: log at the debug level, with the timing of each source object fetched and appended and the details of retries")
+	flag.BoolVar(&audit, "audit", false, "write an audit record of each run, with who ran it, its parameters, source objects, resulting objects and outcome, as a new object under "+AuditPrefix+"/ of the target prefix")
+	flag.StringVar(&notifyURL, "notify-url", "", "webhook receiving a POST of the JSON summary of each run when it finishes, as written with output-json")
+	flag.StringVar(&notifySecret, "notify-secret", "", "key of the HMAC-SHA256 signature of the notifications, sent as "+SignatureHeader+": sha256=<hex>, defaulting to "+NotifySecretEnvVar)
+	flag.Var(&publishList, "publish", "publish a "+RollupCreated+" event of each resulting object to nats://[user:password@]host:port/subject, kafka-rest://host:port/topic through a Kafka REST Proxy, not a broker (kafka-rests:// over TLS) or sns://arn:aws:sns:region:account:topic, repeatable")
+	flag.StringVar(&alertEmail, "alert-email", "", "comma-separated addresses emailed a failure report of each scheduled run that fails, with its error, the last key processed and how to resume")
+	flag.StringVar(&smtpHost, "smtp-host", "", "SMTP server sending the alert-email reports, with STARTTLS when it offers it")
+	flag.IntVar(&smtpPort, "smtp-port", 587, "port of the smtp-host")
+	flag.StringVar(&smtpUser, "smtp-user", "", "user authenticating to the smtp-host, if any")
+	flag.StringVar(&smtpPassword, "smtp-password", "", "password of the smtp-user, defaulting to "+SMTPPasswordEnvVar)
+	flag.StringVar(&smtpFrom, "smtp-from", "", "sender address of the alert-email reports, defaulting to smtp-user")
+	flag.StringVar(&output, "output", "", "local file (file:///path/result), directory (file:///path/dir/) or stdout (-) receiving the single resulting object instead of target-bucket-prefix")
 
 	flag.StringVar(&endpoint, "endpoint", "", "s3 endpoint with config")
 	flag.StringVar(&accessKey, "accesskey", "", "access key of s3 endpoint with config")
 	flag.StringVar(&secretKey, "secretkey", "", "secret key of s3 endpoint with config")
+	flag.StringVar(&sourceConnection.endpoint, "source-endpoint", "", "s3 endpoint of the source, defaulting to endpoint")
+	flag.StringVar(&sourceConnection.accessKey, "source-accesskey", "", "access key of the source endpoint, defaulting to accesskey")
+	flag.StringVar(&sourceConnection.secretKey, "source-secretkey", "", "secret key of the source endpoint, defaulting to secretkey")
+	flag.StringVar(&sourceConnection.sessionToken, "source-session-token", "", "session token of the source endpoint")
+	flag.StringVar(&targetConnection.endpoint, "target-endpoint", "", "s3 endpoint of the target, defaulting to endpoint")
+	flag.StringVar(&targetConnection.accessKey, "target-accesskey", "", "access key of the target endpoint, defaulting to accesskey")
+	flag.StringVar(&targetConnection.secretKey, "target-secretkey", "", "secret key of the target endpoint, defaulting to secretkey")
+	flag.StringVar(&targetConnection.sessionToken, "target-session-token", "", "session token of the target endpoint")
+	flag.BoolVar(&noVerify, "no-verify", false, "do not verify that the bytes read from each source object match its listed size and MD5 ETag")
+	flag.BoolVar(&noTLS, "no-tls", false, "connect to the s3 endpoint over plain http")
+	flag.BoolVar(&insecure, "insecure", false, "skip verification of the s3 endpoint's TLS certificate")
+	flag.StringVar(&caCert, "ca-cert", "", "PEM file of CA certificates to trust in addition to the system ones")
+	flag.StringVar(&clientCert, "client-cert", "", "PEM file of the client certificate for mutual TLS")
+	flag.StringVar(&clientKey, "client-key", "", "PEM file of the private key of the client certificate for mutual TLS")
+	flag.StringVar(&proxy, "proxy", "", "URL of the http proxy through which to connect, overriding HTTP_PROXY/HTTPS_PROXY")
+	flag.StringVar(&region, "region", "", "region of the target bucket, detecting the region of every bucket when empty")
+	flag.StringVar(&lookupStyle, "lookup-style", "auto", "bucket addressing style: path, dns (virtual-hosted) or auto")
+	flag.BoolVar(&anonymous, "anonymous", false, "access the source without credentials, e.g. for public buckets")
+	flag.StringVar(&signature, "signature", "v4", "signature version of requests signed with the credentials given: v4 or v2")
+	flag.StringVar(&alias, "alias", "", "mc alias from which to resolve endpoint, credentials and TLS settings, allowing alias/bucket/prefix syntax")
+	flag.StringVar(&mcConfigDir, "mc-config-dir", defaultMcConfigDir(), "mc config directory containing config.json")
+	flag.StringVar(&sessionToken, "session-token", "", "session token of temporary credentials of s3 endpoint with config")
+	flag.StringVar(&roleARN, "role-arn", "", "ARN of the role to assume with STS AssumeRole, using the credentials given")
+	flag.StringVar(&roleSessionName, "role-session-name", "object-appender", "session name of the assumed role")
+	flag.StringVar(&stsEndpoint, "sts-endpoint", "", "STS endpoint URL, defaulting to the s3 endpoint")
+	flag.StringVar(&webIdentityTokenFile, "web-identity-token-file", "", "file containing a web identity token, e.g. a projected service account token, to obtain credentials with STS AssumeRoleWithWebIdentity")
+	flag.BoolVar(&credentialChain, "credential-chain", false, "fall back to the standard credential chain: environment, shared credentials files, then EC2/ECS instance metadata and IAM roles")
+
+	flag.StringVar(&enableCleanUp, "enable-clean-up", "false", "delete the source objects from the s3 source once appended to a complete resulting object")
+	flag.StringVar(&trashPrefix, "trash-prefix", "", "move the source objects cleaned up into this prefix of the source bucket, outside the source prefix, instead of deleting them")
+	flag.DurationVar(&trashTTL, "trash-ttl", 7*24*time.Hour, "duration objects are kept in the trash-prefix, after which clean-up deletes them")
+	flag.BoolVar(&serverSide, "server-side", false, "compose the resulting object on the server, falling back to client-side copy when compose limits are exceeded")
+
+	var partSizeString string
+	flag.StringVar(&partSizeString, "part-size", DefaultPartSize, "size of each part of the multipart upload, between 5MiB and 5GiB")
+	flag.UintVar(&uploadConcurrency, "upload-concurrency", 4, "number of parts uploaded concurrently")
+	flag.UintVar(&downloadConcurrency, "download-concurrency", 1, "number of source objects downloaded concurrently")
+	flag.BoolVar(&resume, "resume", false, "resume an interrupted run from its checkpoint")
+	flag.BoolVar(&incremental, "incremental", false, "only append objects modified after those appended by the previous incremental run")
+
+	var flushSizeString string
+	flag.BoolVar(&watch, "watch", false, "continuously append objects as they are created in the source")
+	flag.StringVar(&flushSizeString, "flush-size", DefaultFlushSize, "in watch mode, amount of pending objects that triggers a new resulting object")
+	flag.DurationVar(&flushInterval, "flush-interval", 5*time.Minute, "in watch mode, time after the first pending object that triggers a new resulting object")
+	flag.DurationVar(&rotateInterval, "rotate-interval", 0, "in watch mode, window, e.g. 1h, at the end of which the objects created within it are flushed into a resulting object named after its start, instead of flush-interval")
+
+	flag.StringVar(&schedule, "schedule", "", "cron schedule, e.g. \"0 * * * *\", on which to run continuously")
+	flag.DurationVar(&scheduleJitter, "schedule-jitter", 0, "maximum random delay added to each scheduled run")
 
-	flag.StringVar(&enableCleanUp, "enable-clean-up", "false", "delete debugging staging directories")
+	var config string
+	flag.StringVar(&config, "config", "", "YAML file of flag values, overridden by flags given on the command line")
 
 	flag.Parse()
+	if kubernetes {
+		config = startKubernetes(config)
+	}
+	if err := configureLogging(); err != nil {
+		fatal(err)
+	}
+	if verifying && flag.NArg() != 1 {
+		fatal("verify requires the name of the resulting object under target-bucket-prefix: object-appender verify [flags] name")
+	}
+
+	var err error
+	if err = configureTracing(); err != nil {
+		fatal("tracing is invalid:", err)
+	}
+	if config != "" {
+		if err = loadConfig(config); err != nil {
+			fatal("config is invalid:", err)
+		}
+		// The config may set the log-format and log-level
+		if err = configureLogging(); err != nil {
+			fatal(err)
+		}
+	}
+	partSize, err = humanize.ParseBytes(partSizeString)
+	if err != nil {
+		fatal("part-size is invalid:", err)
+	}
+	if err = validateMultipart(); err != nil {
+		fatal(err)
+	}
+	if downloadConcurrency < 1 {
+		fatal("download-concurrency must be at least 1")
+	}
+	flushSize, err = humanize.ParseBytes(flushSizeString)
+	if err != nil {
+		fatal("flush-size is invalid:", err)
+	}
+	if err = compileFilters(); err != nil {
+		fatal(err)
+	}
+	if maxBytesString != "" {
+		if maxBytes, err = humanize.ParseBytes(maxBytesString); err != nil {
+			fatal("max-bytes is invalid:", err)
+		}
+	}
+	if _, ok := Orders[order]; order != "" && order != ManifestOrder && !ok {
+		fatal("order is invalid:", order)
+	}
+	if order == ManifestOrder && keysFrom == "" {
+		fatal("order manifest requires keys-from")
+	}
+	if watch && (maxObjects > 0 || maxBytes > 0) {
+		fatal("watch cannot be combined with max-objects or max-bytes")
+	}
+	if minSizeString != "" {
+		if minSize, err = humanize.ParseBytes(minSizeString); err != nil {
+			fatal("min-size is invalid:", err)
+		}
+	}
+	if maxSizeString != "" {
+		if maxSize, err = humanize.ParseBytes(maxSizeString); err != nil {
+			fatal("max-size is invalid:", err)
+		}
+		if maxSize < minSize {
+			fatal("max-size must be at least min-size")
+		}
+	}
+	if modifiedAfterString != "" {
+		if modifiedAfter, err = parseTime(modifiedAfterString); err != nil {
+			fatal("modified-after is invalid:", err)
+		}
+	}
+	if modifiedBeforeString != "" {
+		if modifiedBefore, err = parseTime(modifiedBeforeString); err != nil {
+			fatal("modified-before is invalid:", err)
+		}
+	}
+	if separator, err = unescape(separator); err != nil {
+		fatal("separator is invalid:", err)
+	}
+	if err = parseTemplates(); err != nil {
+		fatal(err)
+	}
+	if err = parseTimestampTimezone(); err != nil {
+		fatal("timestamp-timezone is invalid:", err)
+	}
+	if nameByHash {
+		if targetNameText != DefaultTargetNameTemplate {
+			fatal("name-by-hash cannot be combined with target-name-template")
+		}
+		targetNameText = HashNameTemplate
+	}
+	if preserveMetadataMode != "" && preserveMetadataMode != FirstMetadata && preserveMetadataMode != MergeMetadata {
+		fatal("preserve-metadata must be first or merge")
+	}
+	if err = parseTargetMetadata(); err != nil {
+		fatal(err)
+	}
+	if outputCRC32C {
+		outputChecksum = true
+	}
+	if err = parseTargetName(); err != nil {
+		fatal("target-name-template is invalid:", err)
+	}
+	if nameHashed && resume {
+		fatal("resume cannot be combined with naming the resulting object by its hash")
+	}
+	if outputChecksum && resume {
+		fatal("resume cannot be combined with output-checksum")
+	}
+	if err = validateOutput(); err != nil {
+		fatal(err)
+	}
+	if err = validateCompression(); err != nil {
+		fatal(err)
+	}
+	if decompressSources != "" && decompressSources != AutoDecompress {
+		fatal("decompress-sources must be", AutoDecompress)
+	}
+	if resume && !rawOutput() {
+		fatal("resume requires the source objects to be appended unchanged")
+	}
+	if watch && resume {
+		fatal("watch cannot be combined with resume")
+	}
+	if workerCount < 1 || workerIndex >= workerCount {
+		fatal("worker-index must be less than worker-count")
+	}
+	if workerCount > 1 && ((output != "" && !strings.HasSuffix(output, "/")) || appendTo != "") {
+		fatal("worker-count requires an output directory and cannot be combined with append-to")
+	}
+	if (lock || leaderElection) && lockTTL < 3*time.Second {
+		fatal("lock-ttl must be at least 3s")
+	}
+	if leaderElection && !watch && schedule == "" {
+		fatal("leader-election requires watch or schedule")
+	}
+	if httpAddr != "" && !watch && schedule == "" {
+		fatal("http-addr requires watch or schedule")
+	}
+	if err = validateAlert(); err != nil {
+		fatal(err)
+	}
+	if err = configureStatsd(); err != nil {
+		fatal("statsd-addr is invalid:", err)
+	}
+	if pushgatewayURL != "" && (watch || schedule != "" || verifying || diffName != "") {
+		fatal("pushgateway-url cannot be combined with watch, schedule, verify or diff, metrics being served on http-addr by the first two")
+	}
+	if watch && skipUnchanged {
+		fatal("watch cannot be combined with skip-unchanged")
+	}
+	if schedule != "" {
+		if watch {
+			fatal("schedule cannot be combined with watch")
+		}
+		cronSchedule, err = cron.ParseStandard(schedule)
+		if err != nil {
+			fatal("schedule is invalid:", err)
+		}
+	}
+
+	secure = true
+	if alias != "" {
+		if err = resolveAlias(); err != nil {
+			fatal("alias is invalid:", err)
+		}
+	}
+	if noTLS {
+		secure = false
+	}
+	if signature != "v4" && signature != "v2" {
+		fatal("signature must be v4 or v2")
+	}
+	if _, ok := BucketLookupStyles[lookupStyle]; !ok {
+		fatal("lookup-style must be one of path, dns or auto")
+	}
+	// The target scheme selects the target driver
+	targetScheme := parseTargetScheme()
+	if targetScheme == GCSScheme && targetConnection.endpoint == "" {
+		targetConnection.endpoint = GCSEndpoint
+	}
+	if ifNoneMatch != "" && ifNoneMatch != FailIfExists && ifNoneMatch != SuffixIfExists {
+		fatal("if-none-match must be fail or suffix")
+	}
+	if ifNoneMatch != "" && output != "" && !strings.HasSuffix(output, "/") {
+		fatal("if-none-match requires an output directory")
+	}
+	if rotateSizeString != "" {
+		if rotateSize, err = humanize.ParseBytes(rotateSizeString); err != nil {
+			fatal("rotate-size is invalid:", err)
+		}
+	}
+	if rotateInterval < 0 || (rotateInterval > 0 && !watch) {
+		fatal("rotate-interval must be positive and requires watch")
+	}
+	if shardBy != RoundRobinShards && shardBy != KeyHashShards {
+		fatal("shard-by must be round-robin or key-hash")
+	}
+	if shards > 1 && (watch || resume || appendTo != "" || rotateSize > 0) {
+		fatal("shards cannot be combined with watch, resume, append-to or rotate-size")
+	}
+	if rotateSize > 0 && (watch || resume || appendTo != "") {
+		fatal("rotate-size cannot be combined with watch, resume or append-to")
+	}
+	if err = parseRecipients(); err != nil {
+		fatal(err)
+	}
+	if err = parseEncryption(); err != nil {
+		fatal(err)
+	}
+	if verifying && (watch || schedule != "" || resume || incremental || clientEncrypted()) {
+		fatal("verify cannot be combined with watch, schedule, resume, incremental or client-side encryption")
+	}
+	if outputJSON == StdoutOutput && output == StdoutOutput {
+		fatal("output-json cannot be written to stdout with the resulting object")
+	}
+	if diffName != "" && (verifying || watch || schedule != "" || resume) {
+		fatal("diff cannot be combined with verify, watch, schedule or resume")
+	}
+	if err = parseCleanUp(); err != nil {
+		fatal(err)
+	}
+	if err = parseChecksumAlgorithm(); err != nil {
+		fatal(err)
+	}
+	if checksumAlgorithm != "" && (targetScheme == AzureScheme || output != "") {
+		fatal("checksum-algorithm requires an s3 target")
+	}
+	if err = parseRetention(); err != nil {
+		fatal(err)
+	}
+	if err = validateManifest(); err != nil {
+		fatal(err)
+	}
+	if (recordPositions() || staging) && output != "" && !strings.HasSuffix(output, "/") {
+		fatal("manifest, index and staging require an output directory")
+	}
+	if err = validateAppend(); err != nil {
+		fatal(err)
+	}
+	if targetEncryption != nil && (targetScheme == AzureScheme || output != "") {
+		fatal("sse and target-sse-c-key require an s3 target")
+	}
+	if (preserveMetadataMode != "" || len(targetMetadataList) > 0 || len(targetTagList) > 0 || provenance) && output != "" {
+		fatal("preserve-metadata, target-metadata, target-tag and provenance require an s3 or azure target")
+	}
+	if storageClass != "" && output != "" {
+		fatal("storage-class requires an s3 or azure target")
+	}
+	if (targetRetention != "" || legalHold) && (targetScheme == AzureScheme || output != "") {
+		fatal("retention-mode and legal-hold require an s3 target")
+	}
+	if appendTo != "" && (targetScheme == AzureScheme || output != "") {
+		fatal("append-to requires an s3 target")
+	}
+	if (targetScheme == AzureScheme || output != "") && resume {
+		fatal("resume requires an s3 target")
+	}
+
+	credentialsFromEnv()
+	defaults := connection{endpoint: endpoint, accessKey: accessKey, secretKey: secretKey, sessionToken: sessionToken}
+	sourceConnection = sourceConnection.orDefault(defaults)
+	targetConnection = targetConnection.orDefault(defaults)
+	if err = parsePublishers(); err != nil {
+		fatal("publish is invalid:", err)
+	}
+	if serverSide && sourceConnection.endpoint != targetConnection.endpoint {
+		slog.Warn("Server-side composition requires the source and target on the same endpoint - using client-side copy")
+		serverSide = false
+	}
 
 	// Parse buckets and prefixes
 	log.Println("Source Bucket/Prefix:", sourceBucketPrefix)
-	log.Println("Target Bucket/Prefix:", targetBucketPrefix)
-	if len(strings.SplitN(sourceBucketPrefix, "/", 2)) != 2 {
-		log.Fatalln("source-bucket-prefix must contain a bucket and prefix")
+	if output != "" {
+		log.Println("Output:", output)
+	} else {
+		log.Println("Target Bucket/Prefix:", targetBucketPrefix)
 	}
-	if len(strings.SplitN(targetBucketPrefix, "/", 2)) != 2 {
-		log.Fatalln("target-bucket-prefix must contain a bucket and prefix")
+	var src source
+	if strings.HasPrefix(sourceBucketPrefix, FileScheme) {
+		// Read the source objects from the local filesystem
+		src = newFileSource(sourceBucketPrefix)
+		if watch {
+			fatal("watch requires an s3 source")
+		}
+	} else {
+		parts := strings.SplitN(sourceBucketPrefix, "/", 2)
+		if len(parts) == 1 && (keysFrom != "" || inventory != "") {
+			// Listed keys need no prefix
+			parts = append(parts, "")
+		}
+		if len(parts) != 2 {
+			fatal("source-bucket-prefix must contain a bucket and prefix")
+		}
+		sourceBucket, sourcePrefix = parts[0], parts[1]
+	}
+	if (keysFrom != "" || inventory != "") && watch {
+		fatal("watch cannot be combined with keys-from or inventory-manifest")
+	}
+	if sourceEncryption != nil && src != nil {
+		fatal("source-sse-c-key requires an s3 source")
+	}
+	if inventory != "" && (keysFrom != "" || src != nil) {
+		fatal("inventory-manifest requires an s3 source and cannot be combined with keys-from")
+	}
+	if output == "" {
+		if len(strings.SplitN(targetBucketPrefix, "/", 2)) != 2 {
+			fatal("target-bucket-prefix must contain a bucket and prefix")
+		}
+		targetBucket = strings.SplitN(targetBucketPrefix, "/", 2)[0]
+		targetPrefix = strings.SplitN(targetBucketPrefix, "/", 2)[1]
 	}
-	sourceBucket = strings.SplitN(sourceBucketPrefix, "/", 2)[0]
-	sourcePrefix = strings.SplitN(sourceBucketPrefix, "/", 2)[1]
-	targetBucket = strings.SplitN(targetBucketPrefix, "/", 2)[0]
-	targetPrefix = strings.SplitN(targetBucketPrefix, "/", 2)[1]
 
-	// Connect to minio
-	s3Client, err := createClient(endpoint)
+	// Stop on SIGTERM, as at pod shutdown, or an interrupt, releasing the leases and saving the state of the run
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Connect to minio, and to the source and target drivers
+	var sourceClient, targetClient *minio.Client
+	s3Target := output == "" && targetScheme != AzureScheme
+	switch {
+	case src == nil && s3Target:
+		sourceClient, targetClient, err = createClients(ctx)
+	case src == nil:
+		sourceClient, err = createClient(sourceConnection, "", anonymous)
+	case s3Target:
+		targetClient, err = createClient(targetConnection, region, false)
+	}
 	if err != nil {
-		log.Printf("Failed to create minio client %v\n", err)
+		slog.Error(fmt.Sprintf("Failed to create minio client %v", err), "error", err.Error())
+		return
+	}
+	if src == nil {
+		src = &s3Source{client: sourceClient}
+	}
+	if cleanUp && !verifying && diffName == "" {
+		if !isS3Source(src) {
+			fatal("enable-clean-up requires an s3 source")
+		}
+		if !staging && !outputChecksum {
+			fatal("enable-clean-up requires staging or output-checksum, verifying the resulting object before the source objects are deleted")
+		}
+		if trashPrefix != "" && strings.HasPrefix(trashPrefix+"/", sourcePrefix) {
+			fatal("trash-prefix must be outside the source prefix, or the objects moved would be appended again")
+		}
+		cleanUpClient = sourceClient
+	}
+	if inventory != "" {
+		src, err = newInventorySource(ctx, sourceClient, inventory)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to read inventory %v - %v", inventory, err), "inventory", inventory, "error", err.Error())
+			return
+		}
+	}
+	if keysFrom != "" {
+		src, err = newKeyListSource(src, keysFrom)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to read keys %v - %v", keysFrom, err), "keys_from", keysFrom, "error", err.Error())
+			return
+		}
+	}
+	if less, ok := Orders[order]; ok {
+		src = &orderedSource{source: src, less: less}
+	}
+	var target sink = &s3Sink{client: targetClient}
+	switch {
+	case output != "":
+		target, err = newFileSink(output)
+	case targetScheme == AzureScheme:
+		target, err = newAzureSink()
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to create target %v", err), "error", err.Error())
+		return
 	}
 
-	ctx := context.Background()
-	now := time.Now().UTC()
-	targetObjectName = targetPrefix + "/" + sourceBucket + "-" + now.Format(TimeFormat)
+	if !verifying && diffName == "" {
+		if err = confirmActions(); err != nil {
+			fatal("Refusing to run:", err)
+		}
+		if temporaryTarget() {
+			collectStaging(ctx, target)
+		}
+	}
+
+	if httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", metricsHandler)
+		serveHTTP(mux)
+		go checkReady(ctx, src, target)
+	}
 
-	buffer = new(bytes.Buffer)
+	switch {
+	case verifying:
+		if err = verifyTarget(ctx, src, target, flag.Arg(0)); err != nil {
+			fatal("Failed to verify", targetObjectName, "-", err)
+		}
+	case diffName != "":
+		differences, err := diffManifest(ctx, src, target, diffName)
+		if err != nil || differences > 0 {
+			// Exit with a failure status on drift, as diff(1) does
+			fatal("Source objects differ from the manifest of", diffName)
+		}
+	case watch:
+		// Append objects as they are created
+		lead(ctx, target, func(ctx context.Context) {
+			startRun(time.Now().UTC())
+			ctx, unlock, err := acquireLock(ctx, target)
+			if err != nil {
+				return
+			}
+			defer unlock()
+			if err = loadRunState(ctx, target); err != nil {
+				return
+			}
+			watchObjects(ctx, sourceClient, target)
+		})
+	case schedule != "":
+		// Append objects on a cron cadence
+		lead(ctx, target, func(ctx context.Context) {
+			scheduleRuns(ctx, src, target)
+		})
+	default:
+		err = runOnce(ctx, src, target)
+		writeTermination(err)
+		pushMetrics(err)
+		if code := exitCode(err); code != 0 {
+			os.Exit(code)
+		}
+	}
+}
 
-	// Download objects to memory
-	err = downloadObjects(ctx, s3Client)
+// Append the source objects into a new resulting object, writing the summary of the run with output-json
+func runOnce(ctx context.Context, src source, target sink) (err error) {
+	startRun(time.Now().UTC())
+	ctx, span := startSpan(ctx, "run", "source", sourceBucketPrefix)
+	start := time.Now()
+	defer func() {
+		endRun(span, start, err)
+		writeSummary(err)
+		writeAudit(target, err)
+		notifyRun(err)
+	}()
+	ctx, unlock, err := acquireLock(ctx, target)
 	if err != nil {
-		return
+		return err
+	}
+	defer func() { err = lockError(ctx, err) }()
+	defer unlock()
+	err = loadRunState(ctx, target)
+	if err != nil {
+		return err
+	}
+	if err = checkInputs(ctx, src, target); err != nil {
+		return err
+	}
+	if shards > 1 {
+		return runShards(ctx, src, target)
+	}
+	if err = claimTargetName(ctx, target); err != nil {
+		return err
 	}
 
-	// Upload single resulting object
-	err = uploadObject(ctx, s3Client)
+	// Compose the resulting object on the server
+	t, targetOK := target.(*s3Sink)
+	if isS3Source(src) && targetOK && serverSide && rawOutput() && resumeFrom == nil && !nameHashed && !outputChecksum && rotateSize == 0 {
+		err = composeObjects(ctx, src, t.client)
+		if err == nil {
+			if err = nameTarget(ctx, target); err != nil {
+				return err
+			}
+			finishRun(ctx, target)
+			return nil
+		}
+		if !errors.Is(err, errComposeLimits) {
+			return err
+		}
+		slog.Warn("Falling back to client-side copy")
+	}
+
+	err = streamObject(ctx, target, func(w io.Writer, rotate rotation) error {
+		return downloadObjects(ctx, src, w, rotate)
+	})
+	if err == nil {
+		err = nameTarget(ctx, target)
+	}
 	if err != nil {
-		return
+		return err
 	}
+	finishRun(ctx, target)
+	return nil
 }
 
-// Create a minio client
-func createClient(configEndpoint string) (*minio.Client, error) {
-	s3Client, err := minio.New(configEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: true,
+// Set the run ID, logged with every message so that runs can be correlated across systems
+func setRunID(id string) {
+	runID = id
+}
+
+// Reset the state left by any previous run, naming the resulting object after the given time
+func startRun(now time.Time) {
+	if !runStart.IsZero() {
+		// Every run after the first of the process gets its own run ID
+		setRunID(uuid.NewString())
+	}
+	objectCount, objectSize, emptyCount = 0, 0, 0
+	runStart, cappedAfter = now, ""
+	resumeFrom, savedParts = nil, 0
+	positions.list, positions.latest, positions.latestKey, positions.first = nil, time.Time{}, "", ""
+	resetMetadata()
+	resetManifest()
+	resetSummary()
+	resetAppended()
+	detectedType = ""
+	contentHash, contentCRC32C, stagedSize, stagedVerified, targetSeq = "", "", -1, false, 1
+	targetObjectName = newTargetObjectName(now)
+}
+
+// Load the state recorded by previous runs
+func loadRunState(ctx context.Context, target sink) error {
+	var err error
+
+	// Resume from the checkpoint of an interrupted run
+	if resume {
+		resumeFrom, err = loadCheckpoint(ctx, target)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to load checkpoint %v - %v", stateObjectName(CheckpointName), err), "object", stateObjectName(CheckpointName), "error", err.Error())
+			return err
+		}
+		if resumeFrom == nil {
+			log.Println("No checkpoint found - starting a new run")
+		} else {
+			log.Printf("Resuming %s after %v parts, from %v at offset %v\n", resumeFrom.TargetObjectName, len(resumeFrom.Parts), resumeFrom.Key, resumeFrom.Offset)
+			targetObjectName = resumeFrom.TargetObjectName
+			positions.latest, positions.latestKey = resumeFrom.LastModified, resumeFrom.LastModifiedKey
+		}
+	}
+
+	// Only append objects newer than the previous incremental run
+	if incremental {
+		watermark, err = loadWatermark(ctx, target)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to load watermark %v - %v", stateObjectName(WatermarkName), err), "object", stateObjectName(WatermarkName), "error", err.Error())
+			return err
+		}
+		log.Println("Appending objects modified after:", watermark.LastModified)
+		if watermark.Key != "" {
+			log.Println("Appending objects remaining after:", watermark.Key)
+		}
+	}
+	return nil
+}
+
+// Stream the contents written by download directly into the upload of the resulting object. When download calls
+// rotate, the resulting object written so far is completed, and download continues writing the next one.
+func streamObject(ctx context.Context, target sink, download func(w io.Writer, rotate rotation) error) error {
+	u := startUpload(ctx, target)
+	rotate := func() (io.Writer, error) {
+		if err := u.finish(); err != nil {
+			return nil, err
+		}
+		if err := completeTarget(ctx, target); err != nil {
+			return nil, err
+		}
+		if err := startTarget(ctx, target); err != nil {
+			return nil, err
+		}
+		u = startUpload(ctx, target)
+		return u.w, nil
+	}
+	err := download(u.w, rotate)
+	if err != nil {
+		u.w.CloseWithError(err)
+		u.finish()
+		return err
+	}
+	return u.finish()
+}
+
+// Record the end of the run started at start and failing with err in the logs, metrics and traces
+func endRun(s *span, start time.Time, err error) {
+	d := time.Since(start)
+	observe("run", d)
+	s.end(err)
+	exportSpans()
+	sendRunStats(err)
+	level, attrs := slog.LevelInfo, []any{"duration", d.Seconds()}
+	if err != nil {
+		level, attrs = slog.LevelError, append(attrs, "error", err.Error())
+	}
+	slog.Log(context.Background(), level, fmt.Sprintf("Finished run in %v", d.Round(time.Millisecond)), attrs...)
+}
+
+// Record the state of a successful run for the next run
+func finishRun(ctx context.Context, target sink) {
+	lastSuccess.Store(time.Now().Unix())
+	saveInputs(ctx, target)
+	if incremental {
+		latest, latestKey := latestModified()
+		if err := saveWatermark(ctx, target, nextWatermark(latest, latestKey, cappedAfter)); err != nil {
+			slog.Error(fmt.Sprintf("Failed to save watermark %v - %v", stateObjectName(WatermarkName), err), "object", stateObjectName(WatermarkName), "error", err.Error())
+		}
+	}
+}
+
+// Create the minio clients of the source and target. With a region, the target is in that region and the
+// region of the source bucket is detected, so that cross-region setups are addressed in the right region.
+// With anonymous, the source is accessed without credentials.
+func createClients(ctx context.Context) (*minio.Client, *minio.Client, error) {
+	targetClient, err := createClient(targetConnection, region, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	sourceRegion := region
+	if region != "" {
+		// Detect the source bucket region, as the client configured with a region always uses it
+		probeClient, err := createClient(sourceConnection, "", anonymous)
+		if err != nil {
+			return nil, nil, err
+		}
+		sourceRegion, err = probeClient.GetBucketLocation(ctx, sourceBucket)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Failed to detect region of bucket: %s - %v", sourceBucket, err), "bucket", sourceBucket, "error", err.Error())
+			sourceRegion = region
+		} else if sourceRegion == "" {
+			sourceRegion = "us-east-1"
+		}
+		if sourceRegion != region {
+			log.Printf("Source bucket %s is in region %s\n", sourceBucket, sourceRegion)
+		}
+	}
+	if sourceRegion == region && !anonymous && sourceConnection == targetConnection {
+		return targetClient, targetClient, nil
+	}
+	sourceClient, err := createClient(sourceConnection, sourceRegion, anonymous)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sourceClient, targetClient, nil
+}
+
+// Create a minio client, without credentials when anonymousAccess is set
+func createClient(c connection, configRegion string, anonymousAccess bool) (*minio.Client, error) {
+	tr, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewStatic("", "", "", credentials.SignatureAnonymous)
+	if !anonymousAccess {
+		creds, err = newCredentials(tr, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s3Client, err := minio.New(c.endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       secure,
+		Transport:    &signingTransport{RoundTripper: tr, creds: creds},
+		Region:       configRegion,
+		BucketLookup: BucketLookupStyles[lookupStyle],
 	})
 	if err != nil {
 		return nil, err
@@ -114,43 +984,35 @@ func createClient(configEndpoint string) (*minio.Client, error) {
 	return s3Client, nil
 }
 
-func downloadObjects(ctx context.Context, s3Client *minio.Client) error {
-	opts := minio.ListObjectsOptions{
-		Recursive: true,
-		Prefix:    sourcePrefix,
+// Upload the contents of r, of unknown length, as the single resulting object
+func uploadObject(ctx context.Context, s3Client *minio.Client, r io.Reader) error {
+	err := makeTargetBucket(ctx, s3Client)
+	if err != nil {
+		return err
 	}
 
-	// List all objects from a bucket-name with a matching prefix.
-	for object := range s3Client.ListObjects(ctx, sourceBucket, opts) {
-		if object.Err != nil {
-			log.Printf("Failed to list: %v - %v\n", object.Key, object.Err)
-			return object.Err
-		} else {
-			objectCount++
-			log.Printf("Obtaining: %v", object.Key)
-			obj, err := s3Client.GetObject(context.Background(), sourceBucket /*bucketName*/, object.Key /*objectName*/, minio.GetObjectOptions{})
-			if err != nil {
-				log.Printf("Failed to obtain object: %v - %v\n", object.Key, err)
-				return err
-			}
-			objectSize += object.Size
-			if _, err := io.Copy(buffer, obj); err != nil {
-				log.Fatalln(err)
-			}
-		}
-	}
-	if objectCount == 0 {
-		log.Println("Failed to find objects - exiting")
-		return errors.New("no objects found")
+	// Wait for the first source object to be appended, so that its content type is detected
+	br := bufio.NewReader(r)
+	br.Peek(1)
+	r = br
+
+	// Upload the object
+	log.Printf("Uploading %s to %s\n", targetObjectName, targetBucketPrefix)
+	opts := minio.PutObjectOptions{ContentType: contentType(), ContentEncoding: contentEncoding(), UserMetadata: targetMetadata(), UserTags: targetTags, StorageClass: storageClass, ServerSideEncryption: targetEncryption}
+	retainPut(&opts, temporaryTarget())
+	err = uploadMultipart(ctx, s3Client, r, opts)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to upload object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return err
 	}
-	log.Printf("Found objects: %v, size: %v", objectCount, objectSize)
 
+	slog.Info(fmt.Sprintf("Successfully uploaded %s to %s", targetObjectName, targetBucketPrefix), "object", targetObjectName)
 	return nil
 }
 
-func uploadObject(ctx context.Context, s3Client *minio.Client) error {
-	// Make a new bucket if it does not exist
-	opts := minio.MakeBucketOptions{}
+// Make the target bucket if it does not exist
+func makeTargetBucket(ctx context.Context, s3Client *minio.Client) error {
+	opts := minio.MakeBucketOptions{Region: region}
 	err := s3Client.MakeBucket(ctx, targetBucket, opts)
 	if err != nil {
 		// Check to see if we already own this bucket
@@ -159,21 +1021,12 @@ func uploadObject(ctx context.Context, s3Client *minio.Client) error {
 			// If bucket already exists and owned then continue
 			log.Printf("Bucket already exists: %s\n", targetBucket)
 		} else if err != nil {
-			log.Printf("Failed to check if bucket exists: %s - %v", targetBucket, err)
+			slog.Error(fmt.Sprintf("Failed to check if bucket exists: %s - %v", targetBucket, err), "bucket", targetBucket, "error", err.Error())
 			return err
 		}
 	} else {
 		log.Printf("Successfully created bucket %s\n", targetBucket)
 	}
 
-	// Upload the object
-	log.Printf("Uploading %s to %s\n", targetObjectName, targetBucketPrefix)
-	_, err = s3Client.PutObject(ctx, targetBucket /*bucketName*/, targetObjectName /*objectName*/, buffer /*reader*/, objectSize /*objectSize*/, minio.PutObjectOptions{ContentType: ContentType})
-	if err != nil {
-		log.Printf("Failed to upload object %v - %v\n", targetObjectName, err)
-		return err
-	}
-
-	log.Printf("Successfully uploaded %s to %s\n", targetObjectName, targetBucketPrefix)
 	return nil
 }
@@ -0,0 +1,174 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// ManifestExtension is the extension of the manifest sidecar object, named after the resulting object
+const ManifestExtension = ".manifest.json"
+
+// index values, selecting the encoding of the byte-range index sidecar object
+const (
+	// CSVIndex writes the index as CSV rows of key, offset and length
+	CSVIndex = "csv"
+	// BinaryIndex writes the index as records of the big-endian uint32 length of the key, the key, and the
+	// big-endian uint64 offset and length
+	BinaryIndex = "binary"
+)
+
+// manifestEntry is a source object appended to the resulting object, with its position within it
+type manifestEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag,omitempty"`
+	// Offset and Length are the bytes of the resulting object the source object was written as
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// manifestObject is the contents of the manifest sidecar object
+type manifestObject struct {
+	Object  string          `json:"object"`
+	RunID   string          `json:"runId"`
+	Objects []manifestEntry `json:"objects"`
+}
+
+// manifest records the source objects appended to the resulting object with manifest or index
+var manifest struct {
+	sync.Mutex
+	entries []manifestEntry
+}
+
+// Return whether the positions of the source objects within the resulting object are recorded
+func recordPositions() bool {
+	return writeManifest || indexFormat != ""
+}
+
+// Check that the manifest and index can be recorded, as the positions of source objects are only known in an
+// output they are written to byte for byte
+func validateManifest() error {
+	if indexFormat != "" && indexFormat != CSVIndex && indexFormat != BinaryIndex {
+		return errors.New("index must be csv or binary")
+	}
+	if !recordPositions() {
+		return nil
+	}
+	if (format != "" && format != GzipMembersFormat && format != "ndjson") || compress != "" || clientEncrypted() {
+		return errors.New("manifest and index cannot be combined with format " + format + ", compress or client-side encryption")
+	}
+	if resume || appendTo != "" {
+		return errors.New("manifest and index cannot be combined with resume or append-to")
+	}
+	return nil
+}
+
+// Record that the source object was written as length bytes at offset of the resulting object
+func addManifest(object minio.ObjectInfo, offset, length int64) {
+	if !recordPositions() {
+		return
+	}
+	manifest.Lock()
+	manifest.entries = append(manifest.entries, manifestEntry{Key: object.Key, Size: object.Size, ETag: object.ETag, Offset: offset, Length: length})
+	manifest.Unlock()
+}
+
+// Forget the source objects recorded, starting a new resulting object
+func resetManifest() {
+	manifest.Lock()
+	manifest.entries = nil
+	manifest.Unlock()
+}
+
+// Write the manifest and index of the resulting object as its sidecar objects
+func saveManifest(ctx context.Context, target sink) error {
+	manifest.Lock()
+	entries := manifest.entries
+	manifest.Unlock()
+	if writeManifest {
+		data, err := json.MarshalIndent(manifestObject{Object: targetObjectName, RunID: runID, Objects: entries}, "", "  ")
+		if err != nil {
+			return err
+		}
+		name := targetObjectName + ManifestExtension
+		if err = target.putState(ctx, name, data); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write manifest %v - %v", name, err), "object", name, "error", err.Error())
+			return err
+		}
+	}
+	if indexFormat != "" {
+		data, err := encodeIndex(entries)
+		if err != nil {
+			return err
+		}
+		name := targetObjectName + ".index." + indexFormat
+		if indexFormat == BinaryIndex {
+			name = targetObjectName + ".index"
+		}
+		if err = target.putState(ctx, name, data); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write index %v - %v", name, err), "object", name, "error", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the byte-range index of the source objects in the index format
+func encodeIndex(entries []manifestEntry) ([]byte, error) {
+	var b bytes.Buffer
+	if indexFormat == BinaryIndex {
+		for _, e := range entries {
+			b.Write(binary.BigEndian.AppendUint32(nil, uint32(len(e.Key))))
+			b.WriteString(e.Key)
+			b.Write(binary.BigEndian.AppendUint64(nil, uint64(e.Offset)))
+			b.Write(binary.BigEndian.AppendUint64(nil, uint64(e.Length)))
+		}
+		return b.Bytes(), nil
+	}
+	w := csv.NewWriter(&b)
+	w.Write([]string{"key", "offset", "length"})
+	for _, e := range entries {
+		w.Write([]string{e.Key, strconv.FormatInt(e.Offset, 10), strconv.FormatInt(e.Length, 10)})
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// countingWriter counts the bytes written through it, locating the source objects within the resulting object
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// preserve-metadata values, selecting the source object user metadata stored on the resulting object
+const (
+	// FirstMetadata preserves the user metadata of the first source object appended
+	FirstMetadata = "first"
+	// MergeMetadata preserves the user metadata of every source object appended, joining the distinct values
+	// of each name with commas
+	MergeMetadata = "merge"
+)
+
+// MaxUserMetadataSize is the most user metadata stored with an s3 object
+const MaxUserMetadataSize = 2048
+
+// User metadata of the source objects appended to the resulting object, by normalized name
+var preserved struct {
+	sync.Mutex
+	values map[string][]string
+}
+
+// Return the user metadata of the source object, fetching it when the listing did not include it
+func objectMetadata(ctx context.Context, src source, object minio.ObjectInfo) (map[string]string, error) {
+	if len(object.UserMetadata) > 0 {
+		return object.UserMetadata, nil
+	}
+	return src.metadata(ctx, object.Key)
+}
+
+// Record the user metadata of a source object appended to the resulting object
+func preserveMetadata(metadata map[string]string) {
+	preserved.Lock()
+	defer preserved.Unlock()
+	if preserved.values == nil {
+		preserved.values = map[string][]string{}
+	}
+	for name, value := range metadata {
+		name = metadataName(name)
+		values := preserved.values[name]
+		if !slices.Contains(values, value) {
+			preserved.values[name] = append(values, value)
+		}
+	}
+}
+
+// Forget the user metadata recorded for the previous resulting object
+func resetMetadata() {
+	preserved.Lock()
+	preserved.values = nil
+	preserved.Unlock()
+}
+
+// Return the user metadata preserved on the resulting object, leaving out names beyond MaxUserMetadataSize
+func preservedMetadata() map[string]string {
+	preserved.Lock()
+	defer preserved.Unlock()
+	names := make([]string, 0, len(preserved.values))
+	for name := range preserved.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metadata := map[string]string{}
+	size := len(RunIDMetadata) + len(runID)
+	for _, name := range names {
+		value := strings.Join(preserved.values[name], ",")
+		if size+len(name)+len(value) > MaxUserMetadataSize {
+			slog.Warn(fmt.Sprintf("Unable to preserve metadata beyond %v bytes: %v", MaxUserMetadataSize, name), "metadata", name, "bytes", MaxUserMetadataSize)
+			continue
+		}
+		size += len(name) + len(value)
+		metadata[name] = value
+	}
+	return metadata
+}
+
+var (
+	// extraMetadata is the user metadata given with target-metadata
+	extraMetadata map[string]string
+	// targetTags are the tags of the resulting object given with target-tag
+	targetTags map[string]string
+)
+
+// Parse the name=value pairs of target-metadata and the key=value pairs of target-tag
+func parseTargetMetadata() error {
+	for _, pair := range targetMetadataList {
+		name, value, ok := strings.Cut(pair, "=")
+		name = metadataName(name)
+		if !ok || name == "" {
+			return fmt.Errorf("invalid target-metadata %q, must be name=value", pair)
+		}
+		if extraMetadata == nil {
+			extraMetadata = map[string]string{}
+		}
+		extraMetadata[name] = value
+	}
+	for _, pair := range targetTagList {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid target-tag %q, must be key=value", pair)
+		}
+		if targetTags == nil {
+			targetTags = map[string]string{}
+		}
+		targetTags[key] = value
+	}
+	if _, err := tags.NewTags(targetTags, true); err != nil {
+		return fmt.Errorf("target-tag is invalid: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets of the operation latency histograms
+var LatencyBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// Counters of the process, exposed on /metrics
+var (
+	objectsListed, objectsAppended, objectsSkipped, objectsFailed atomic.Int64
+	bytesDownloaded, bytesUploaded                                atomic.Int64
+	// lastSuccess is the Unix time of the last successful run
+	lastSuccess atomic.Int64
+)
+
+// histogram counts the observations of an operation latency in LatencyBuckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// latencies are the histograms of the latencies of list, get, put and run operations
+var latencies = struct {
+	sync.Mutex
+	byOperation map[string]*histogram
+}{byOperation: map[string]*histogram{}}
+
+// Record that an operation took d
+func observe(operation string, d time.Duration) {
+	latencies.Lock()
+	defer latencies.Unlock()
+	h := latencies.byOperation[operation]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(LatencyBuckets))}
+		latencies.byOperation[operation] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+	sendTiming(operation, d)
+}
+
+// Return the labels of every metric, the source bucket and prefix
+func metricLabels() string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return fmt.Sprintf(`source_bucket="%s",source_prefix="%s"`, escape.Replace(sourceBucket), escape.Replace(sourcePrefix))
+}
+
+// Write the metrics in the Prometheus text exposition format
+func writeMetrics(w io.Writer) {
+	labels := metricLabels()
+	metric := func(name, kind, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{%s} %d\n", name, help, name, kind, name, labels, value)
+	}
+	metric("object_appender_objects_listed_total", "counter", "Source objects listed.", objectsListed.Load())
+	metric("object_appender_objects_appended_total", "counter", "Source objects appended.", objectsAppended.Load())
+	metric("object_appender_objects_skipped_total", "counter", "Source objects listed but not selected.", objectsSkipped.Load())
+	metric("object_appender_objects_failed_total", "counter", "Source objects failing to be appended.", objectsFailed.Load())
+	metric("object_appender_bytes_downloaded_total", "counter", "Bytes of the source objects appended.", bytesDownloaded.Load())
+	metric("object_appender_bytes_uploaded_total", "counter", "Bytes of the resulting objects uploaded.", bytesUploaded.Load())
+	metric("object_appender_last_success_timestamp_seconds", "gauge", "Unix time of the last successful run.", lastSuccess.Load())
+
+	latencies.Lock()
+	defer latencies.Unlock()
+	const name = "object_appender_operation_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of listing, getting source objects, putting resulting objects and runs.\n# TYPE %s histogram\n", name, name)
+	operations := make([]string, 0, len(latencies.byOperation))
+	for operation := range latencies.byOperation {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		h := latencies.byOperation[operation]
+		for i, bound := range LatencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,operation=\"%s\",le=\"%g\"} %d\n", name, labels, operation, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,operation=\"%s\",le=\"+Inf\"} %d\n", name, labels, operation, h.count)
+		fmt.Fprintf(w, "%s_sum{%s,operation=\"%s\"} %g\n", name, labels, operation, h.sum)
+		fmt.Fprintf(w, "%s_count{%s,operation=\"%s\"} %d\n", name, labels, operation, h.count)
+	}
+}
+
+// Serve the metrics for Prometheus to scrape
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// MinPartSize is the smallest part size accepted by s3 for all but the last part
+	MinPartSize = 1024 * 1024 * 5
+	// MaxPartSize is the largest part size accepted by s3
+	MaxPartSize = 1024 * 1024 * 1024 * 5
+	// MaxPartCount is the maximum number of parts in a single multipart upload
+	MaxPartCount = 10000
+)
+
+// Upload the contents of r as a multipart upload of part-size parts, with up to uploadConcurrency parts in flight.
+// At most uploadConcurrency parts are held in memory at a time. After each part, a checkpoint is saved so that
+// an interrupted upload may be resumed, continuing from resumeFrom when set.
+func uploadMultipart(ctx context.Context, s3Client *minio.Client, r io.Reader, opts minio.PutObjectOptions) error {
+	core := &minio.Core{Client: s3Client}
+	c := &checkpoint{TargetObjectName: targetObjectName, PartSize: partSize}
+	if resumeFrom != nil {
+		c.UploadID, c.PartSize, c.Parts = resumeFrom.UploadID, resumeFrom.PartSize, resumeFrom.Parts
+	} else {
+		uploadID, err := core.NewMultipartUpload(ctx, targetBucket, targetObjectName, checksumUploadOptions(opts))
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to create multipart upload %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+			return err
+		}
+		c.UploadID = uploadID
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    = map[int]minio.CompletePart{}
+		firstErr error
+		// Checkpoints are saved one at a time, outside mu, never replacing a later one
+		saving sync.Mutex
+		saved  = len(c.Parts)
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	// Save a checkpoint whenever the contiguous run of uploaded parts grows
+	complete := func(part minio.CompletePart) {
+		mu.Lock()
+		parts[part.PartNumber] = part
+		advanced := false
+		for {
+			next, ok := parts[len(c.Parts)+1]
+			if !ok {
+				break
+			}
+			c.Parts = append(c.Parts, next)
+			delete(parts, next.PartNumber)
+			advanced = true
+		}
+		if !advanced {
+			mu.Unlock()
+			return
+		}
+		snapshot := *c
+		snapshot.Parts = slices.Clone(c.Parts)
+		mu.Unlock()
+
+		snapshot.Key, snapshot.Offset = locate(int64(len(snapshot.Parts)) * int64(snapshot.PartSize))
+		snapshot.LastModified, snapshot.LastModifiedKey = latestModified()
+		saving.Lock()
+		defer saving.Unlock()
+		if len(snapshot.Parts) <= saved {
+			return
+		}
+		if err := saveCheckpoint(ctx, &s3Sink{client: s3Client}, &snapshot); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to save checkpoint %v - %v", stateObjectName(CheckpointName), err), "object", stateObjectName(CheckpointName), "error", err.Error())
+			return
+		}
+		saved = len(snapshot.Parts)
+	}
+
+	// Buffers are recycled between parts, bounding memory and concurrency together. They are allocated as parts
+	// are read, so that small uploads do not allocate upload-concurrency parts.
+	buffers := make(chan []byte, uploadConcurrency)
+	for i := 0; i < int(uploadConcurrency); i++ {
+		buffers <- nil
+	}
+
+	for partNumber := len(c.Parts) + 1; ; partNumber++ {
+		buf, ok := nextBuffer(ctx, buffers, int(c.PartSize))
+		if !ok {
+			break
+		}
+		n, rerr := io.ReadFull(r, buf)
+		if rerr == io.EOF && partNumber > 1 {
+			buffers <- buf
+			break
+		}
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			fail(rerr)
+			break
+		}
+		if partNumber > MaxPartCount {
+			fail(fmt.Errorf("resulting object exceeds %v parts of %v bytes", MaxPartCount, c.PartSize))
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int, buf []byte, n int) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+			sum := md5.Sum(buf[:n])
+			// Writes protected by Object Lock must carry their MD5
+			md5Base64 := ""
+			if opts.Mode != "" || opts.LegalHold != "" {
+				md5Base64 = base64.StdEncoding.EncodeToString(sum[:])
+			}
+			ctx, span := startSpan(ctx, "part", "object", targetObjectName, "part", strconv.Itoa(partNumber))
+			header, checksum := partChecksum(buf[:n])
+			part, err := core.PutObjectPart(withPartHeaders(ctx, header, buf[:n]), targetBucket, targetObjectName, c.UploadID, partNumber, bytes.NewReader(buf[:n]), int64(n), md5Base64, "", opts.ServerSideEncryption)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to upload part %v of %v - %v", partNumber, targetObjectName, err), "part", partNumber, "object", targetObjectName, "error", err.Error())
+				span.end(err)
+				fail(err)
+				return
+			}
+			span.end(nil)
+			// The ETag of a part is its MD5 unless it is encrypted with SSE-KMS or SSE-C
+			if etag := strings.Trim(part.ETag, `"`); outputChecksum && (opts.ServerSideEncryption == nil || opts.ServerSideEncryption.Type() == encrypt.S3) &&
+				etag != hex.EncodeToString(sum[:]) {
+				err = fmt.Errorf("ETag %v does not match MD5 %v", etag, hex.EncodeToString(sum[:]))
+				slog.Error(fmt.Sprintf("Failed to verify part %v of %v - %v", partNumber, targetObjectName, err), "part", partNumber, "object", targetObjectName, "error", err.Error())
+				fail(err)
+				return
+			}
+			// The parts of an upload created with a checksum-algorithm are completed with their checksums
+			checksum.PartNumber, checksum.ETag = part.PartNumber, part.ETag
+			complete(checksum)
+		}(partNumber, buf, n)
+
+		if rerr != nil {
+			// Short read, this was the last part
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		if saved > 0 {
+			// Keep the upload so that it may be resumed from the last checkpoint
+			slog.Warn(fmt.Sprintf("Saved checkpoint after %v parts, run again with --resume to continue", saved), "object", targetObjectName, "parts", saved)
+			savedParts = saved
+			return firstErr
+		}
+		if err := core.AbortMultipartUpload(context.Background(), targetBucket, targetObjectName, c.UploadID); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to abort multipart upload %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		}
+		return firstErr
+	}
+
+	conditionalPut(&opts, temporaryTarget())
+	_, err := core.CompleteMultipartUpload(ctx, targetBucket, targetObjectName, c.UploadID, c.Parts, opts)
+	if preconditionFailed(err) {
+		core.AbortMultipartUpload(context.Background(), targetBucket, targetObjectName, c.UploadID)
+		err = errTargetExists
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to complete multipart upload %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return err
+	}
+	if saved > 0 {
+		removeCheckpoint(ctx, &s3Sink{client: s3Client})
+	}
+	return nil
+}
+
+// Return a buffer of size from buffers once one is free, allocating it on first use, or false when ctx is done
+func nextBuffer(ctx context.Context, buffers chan []byte, size int) ([]byte, bool) {
+	select {
+	case buf := <-buffers:
+		if buf == nil {
+			buf = make([]byte, size)
+		}
+		return buf, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// Validate the configured part size and upload concurrency
+func validateMultipart() error {
+	if partSize < MinPartSize || partSize > MaxPartSize {
+		return errors.New("part-size must be between 5MiB and 5GiB")
+	}
+	if uploadConcurrency < 1 {
+		return errors.New("upload-concurrency must be at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultTargetNameTemplate names the resulting object after the source bucket and the time of the run
+const DefaultTargetNameTemplate = "{{.SourceBucket}}-{{.Timestamp}}"
+
+// if-none-match values, protecting existing objects from being overwritten by the resulting object
+const (
+	// FailIfExists fails the run when the resulting object already exists
+	FailIfExists = "fail"
+	// SuffixIfExists names the resulting object with the first free numeric suffix when it already exists
+	SuffixIfExists = "suffix"
+)
+
+var errTargetExists = errors.New("target object already exists")
+
+// HashNameTemplate is the target-name-template of name-by-hash
+const HashNameTemplate = "{{.SHA256}}"
+
+var (
+	targetNameTemplate *template.Template
+	timestampLocation  = time.UTC
+	// The name or metadata depend on the appended objects, so the resulting object is uploaded under a temporary
+	// name first
+	nameDeferred bool
+	// The name depends on the SHA-256 of the resulting object, computed while it is uploaded
+	nameHashed bool
+	// contentHash is the hex SHA-256 of the resulting object uploaded
+	contentHash string
+	// contentCRC32C is the hex CRC32C of the resulting object uploaded with output-crc32c
+	contentCRC32C string
+)
+
+// nameData is the data of the target-name-template
+type nameData struct {
+	SourceBucket string
+	SourcePrefix string
+	RunID        string
+	// ObjectCount is the number of source objects appended
+	ObjectCount int64
+	// Groups are the named groups of the key regex matched in the key of the first source object appended
+	Groups map[string]string
+	// SHA256 is the hex SHA-256 of the contents of the resulting object
+	SHA256 string
+	// Timestamp is the time of the run in the timestamp-format
+	Timestamp string
+	time      time.Time
+}
+
+// Date returns the time of the run in the given layout, e.g. "2006/01/02"
+func (d nameData) Date(layout string) string {
+	return d.time.Format(layout)
+}
+
+// Load the timestamp-timezone in which resulting objects are named, e.g. Local or Europe/Paris
+func parseTimestampTimezone() error {
+	var err error
+	timestampLocation, err = time.LoadLocation(timestampTimezone)
+	return err
+}
+
+// Parse the target-name-template, checking that it executes
+func parseTargetName() error {
+	var err error
+	targetNameTemplate, err = template.New("target-name-template").Option("missingkey=zero").Parse(targetNameText)
+	if err != nil {
+		return err
+	}
+	nameHashed = strings.Contains(targetNameText, ".SHA256")
+	nameDeferred = nameHashed || strings.Contains(targetNameText, ".ObjectCount") || strings.Contains(targetNameText, ".Groups") ||
+		preserveMetadataMode != "" || outputChecksum || provenance
+	_, err = executeTargetName(nameData{Groups: map[string]string{}, time: time.Now().In(timestampLocation)})
+	return err
+}
+
+// Return the name of the resulting object, relative to the target prefix, described by data
+func executeTargetName(data nameData) (string, error) {
+	var b strings.Builder
+	if err := targetNameTemplate.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.Trim(b.String(), "/"), nil
+}
+
+// Return the name of a resulting object created at the given time, with count source objects appended, the first
+// of which is first, falling back to the default name should the template fail
+func targetName(now time.Time, count int64, first string) string {
+	now = now.In(timestampLocation)
+	data := nameData{
+		SourceBucket: sourceBucket,
+		SourcePrefix: strings.Trim(sourcePrefix, "/"),
+		RunID:        runID,
+		ObjectCount:  count,
+		Groups:       keyGroups(first),
+		SHA256:       contentHash,
+		Timestamp:    now.Format(timestampFormat),
+		time:         now,
+	}
+	name, err := executeTargetName(data)
+	if err == nil && name == "" {
+		err = errors.New("target-name-template named no object")
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to name target object - %v", err), "error", err.Error())
+		name = sourceBucket + "-" + now.Format(timestampFormat)
+	}
+	if rotateSize > 0 {
+		name = fmt.Sprintf("%s-%04d", name, targetSeq)
+	}
+	if shards > 1 {
+		name = fmt.Sprintf("%s-%04d-of-%04d", name, targetShard, shards)
+	}
+	if workerCount > 1 {
+		name += "-" + workerSuffix()
+	}
+	return targetPrefix + "/" + name + outputExtension()
+}
+
+// Return whether the resulting object is uploaded under a temporary name, as its name depends on the appended
+// objects, it is appended to the append-to object or it is staged
+func temporaryTarget() bool {
+	return nameDeferred || appendTo != "" || staging
+}
+
+// Return the name of a resulting object created at the given time, or the temporary name it is uploaded under
+// when its name depends on the appended objects
+func newTargetObjectName(now time.Time) string {
+	if temporaryTarget() {
+		return stagingName()
+	}
+	return targetName(now, 0, "")
+}
+
+// Claim the name of the resulting object with if-none-match before writing it, unless it is a temporary name or
+// the name of the resumed run
+func claimTargetName(ctx context.Context, target sink) error {
+	if temporaryTarget() || resumeFrom != nil {
+		return nil
+	}
+	name, err := claimName(ctx, target, targetObjectName)
+	if err != nil {
+		return err
+	}
+	targetObjectName = name
+	return nil
+}
+
+// Complete the uploaded resulting object: put it in place, write its manifest, publish its event, then clean up
+// the source objects appended to it
+func nameTarget(ctx context.Context, target sink) error {
+	if err := placeTarget(ctx, target); err != nil {
+		return err
+	}
+	recordTarget(targetObjectName)
+	if err := saveManifest(ctx, target); err != nil {
+		return err
+	}
+	publishRollup()
+	return cleanUpSources(ctx, target)
+}
+
+// Rename the uploaded resulting object from its temporary name once its name is known, or append it to the
+// append-to object
+func placeTarget(ctx context.Context, target sink) error {
+	if err := verifyStaged(ctx, target); err != nil {
+		target.removeState(ctx, targetObjectName)
+		return err
+	}
+	if appendTo != "" {
+		return extendTarget(ctx, target.(*s3Sink))
+	}
+	if !nameDeferred && !staging {
+		return nil
+	}
+	base := targetName(runStart, objectCount, firstObject())
+	for {
+		name, err := claimName(ctx, target, base)
+		if err != nil {
+			// Remove the temporary object, as any state object
+			target.removeState(ctx, targetObjectName)
+			return err
+		}
+		log.Printf("Renaming %s to %s\n", targetObjectName, name)
+		err = target.rename(ctx, targetObjectName, name)
+		if errors.Is(err, errTargetExists) && ifNoneMatch == SuffixIfExists {
+			// Created since it was claimed, the next suffix is claimed
+			continue
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to rename object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+			if errors.Is(err, errTargetExists) {
+				target.removeState(ctx, targetObjectName)
+			}
+			return err
+		}
+		targetObjectName = name
+		logChecksum()
+		return nil
+	}
+}
+
+// Return the name of the resulting object name to be written with if-none-match, failing if it already exists,
+// or suffixing it until it does not. Writes under the name are conditional, as another run may claim it too.
+func claimName(ctx context.Context, target sink, name string) (string, error) {
+	if ifNoneMatch == "" {
+		return name, nil
+	}
+	for seq, candidate := 0, name; ; seq++ {
+		if seq > 0 {
+			candidate = suffixName(name, seq)
+		}
+		exists, err := target.exists(ctx, candidate)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to check object %v - %v", candidate, err), "object", candidate, "error", err.Error())
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		if ifNoneMatch == FailIfExists {
+			slog.Error(fmt.Sprintf("Failed to write object %v - %v", candidate, errTargetExists), "object", candidate, "error", errTargetExists.Error())
+			return "", errTargetExists
+		}
+	}
+}
+
+// Return the name of the resulting object suffixed with seq, before its extension
+func suffixName(name string, seq int) string {
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, outputExtension()), seq, outputExtension())
+}
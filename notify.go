@@ -0,0 +1,79 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// NotifySecretEnvVar is the environment variable of the notify-secret when the flag is not given
+	NotifySecretEnvVar = "OBJECT_APPENDER_NOTIFY_SECRET"
+	// SignatureHeader is the header of the HMAC-SHA256 of the body of notifications, as sha256=<hex>
+	SignatureHeader = "X-Object-Appender-Signature"
+)
+
+// Return the notify-secret, or the secret of its environment variable
+func notifySigningKey() string {
+	if notifySecret != "" {
+		return notifySecret
+	}
+	return os.Getenv(NotifySecretEnvVar)
+}
+
+// POST the summary of the run ending with err to notify-url, signed with the notify-secret if any, so that
+// downstream pipelines can trigger on new resulting objects
+func notifyRun(err error) {
+	if notifyURL == "" {
+		return
+	}
+	body, err := json.Marshal(summarize(err))
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to notify %v - %v", notifyURL, err), "url", notifyURL, "error", err.Error())
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, notifyURL, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to notify %v - %v", notifyURL, err), "url", notifyURL, "error", err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := notifySigningKey(); key != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to notify %v - %v", notifyURL, err), "url", notifyURL, "error", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Warn(fmt.Sprintf("Failed to notify %v - %v", notifyURL, resp.Status), "url", notifyURL, "status", resp.Status)
+	}
+}
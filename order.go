@@ -0,0 +1,135 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"sort"
+	"strings"
+)
+
+// ManifestOrder is the order appending objects exactly in the order of the keys-from list
+const ManifestOrder = "manifest"
+
+// Orders maps the order values to the comparison of source objects appended in that order
+var Orders = map[string]func(a, b minio.ObjectInfo) bool{
+	"key-asc":  func(a, b minio.ObjectInfo) bool { return a.Key < b.Key },
+	"key-desc": func(a, b minio.ObjectInfo) bool { return a.Key > b.Key },
+	// Objects modified at the same time are appended in key order
+	"mtime-asc": func(a, b minio.ObjectInfo) bool {
+		if !a.LastModified.Equal(b.LastModified) {
+			return a.LastModified.Before(b.LastModified)
+		}
+		return a.Key < b.Key
+	},
+	"natural": func(a, b minio.ObjectInfo) bool { return naturalLess(a.Key, b.Key) },
+}
+
+// Return whether a sorts before b, comparing runs of digits by their numeric value, so that part-2 sorts
+// before part-10. Equal numbers sort with fewer leading zeros first.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitRun(a), digitRun(b)
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			a, b = a[len(na):], b[len(nb):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// Return whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// Return the leading run of digits of s
+func digitRun(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// orderedSource lists the objects of another source sorted in an order, from a snapshot of its whole listing
+type orderedSource struct {
+	source
+	less func(a, b minio.ObjectInfo) bool
+}
+
+// Send the objects in order. As the order need not be key order, startAfter resumes after its position in the
+// sorted snapshot, failing if it is no longer listed.
+func (s *orderedSource) list(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		var snapshot []minio.ObjectInfo
+		for object := range s.source.list(ctx, "") {
+			if object.Err != nil {
+				objects <- object
+				return
+			}
+			snapshot = append(snapshot, object)
+		}
+		sort.SliceStable(snapshot, func(i, j int) bool { return s.less(snapshot[i], snapshot[j]) })
+
+		if startAfter != "" {
+			i := 0
+			for i < len(snapshot) && snapshot[i].Key != startAfter {
+				i++
+			}
+			if i == len(snapshot) {
+				objects <- minio.ObjectInfo{Key: startAfter, Err: fmt.Errorf("object %v to continue after is no longer listed", startAfter)}
+				return
+			}
+			snapshot = snapshot[i+1:]
+		}
+		for _, object := range snapshot {
+			select {
+			case objects <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return objects
+}
+
+// Sort the objects in the configured order, if any
+func sortObjects(objects []minio.ObjectInfo) {
+	if less, ok := Orders[order]; ok {
+		sort.SliceStable(objects, func(i, j int) bool { return less(objects[i], objects[j]) })
+	}
+}
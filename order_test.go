@@ -0,0 +1,49 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import "testing"
+
+func TestNaturalLess(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"part-2", "part-10", true},
+		{"part-10", "part-2", false},
+		{"part-9", "part-09", true},
+		{"part-09", "part-9", false},
+		{"part-7b", "part-007", true},
+		{"part-007", "part-7b", false},
+		{"part-007", "part-8", true},
+		{"0", "00", true},
+		{"00", "0", false},
+		{"10", "9a", false},
+		{"a", "a1", true},
+		{"1", "a", true},
+		{"", "a", true},
+		{"a", "", false},
+		{"", "0", true},
+		{"", "", false},
+		{"part-2", "part-2", false},
+	} {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the version of object-appender, set with -ldflags "-X main.version=..." when built for release
+var version string
+
+// Return the version of object-appender, falling back to the version of the module it was built from
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// Return the provenance metadata recording where the resulting object came from, with provenance
+func provenanceMetadata() map[string]string {
+	return map[string]string{
+		"Object-Appender-Source-Bucket": sourceBucket,
+		"Object-Appender-Source-Prefix": strings.Trim(sourcePrefix, "/"),
+		"Object-Appender-Object-Count":  strconv.FormatInt(objectCount, 10),
+		"Object-Appender-Size":          strconv.FormatInt(objectSize, 10),
+		"Object-Appender-Version":       toolVersion(),
+		"Object-Appender-Run-Time":      runStart.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PushJob is the job grouping the metrics pushed to the Pushgateway
+const PushJob = "object_appender"
+
+// Return the URL of the group of the metrics pushed. POST replaces the metrics of the same names in the group, so
+// the runs of each source bucket and prefix, and each worker, are grouped apart. Label values are base64url
+// encoded, as they may contain slashes or be empty.
+func pushURL() string {
+	url := strings.TrimSuffix(pushgatewayURL, "/") + "/metrics/job/" + PushJob +
+		"/source_bucket@base64/" + pushLabel(sourceBucket) + "/source_prefix@base64/" + pushLabel(sourcePrefix)
+	if workerCount > 1 {
+		url += "/worker/" + strconv.FormatUint(uint64(workerIndex), 10)
+	}
+	return url
+}
+
+// Return the label value encoded in a grouping key, an empty value being encoded as "="
+func pushLabel(value string) string {
+	if value == "" {
+		return "="
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// Push the metrics of the run ending with err to the Pushgateway, as one-shot runs cannot be scraped. The last
+// success is only pushed by successful runs, so that it survives the failed runs following them.
+func pushMetrics(err error) {
+	if pushgatewayURL == "" {
+		return
+	}
+	labels := metricLabels()
+	var body bytes.Buffer
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n%s{%s} %v\n", name, help, name, name, labels, value)
+	}
+	success := 0
+	if err == nil {
+		success = 1
+		gauge("object_appender_last_success_timestamp_seconds", "Unix time of the last successful run.", lastSuccess.Load())
+	}
+	gauge("object_appender_run_success", "Whether the last run succeeded.", success)
+	gauge("object_appender_run_duration_seconds", "Duration of the last run.", time.Since(runStart).Seconds())
+	gauge("object_appender_run_objects", "Source objects appended by the last run.", objectsAppended.Load())
+	gauge("object_appender_run_bytes_downloaded", "Bytes of the source objects appended by the last run.", bytesDownloaded.Load())
+	gauge("object_appender_run_bytes_uploaded", "Bytes of the resulting objects uploaded by the last run.", bytesUploaded.Load())
+
+	url := pushURL()
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "text/plain; version=0.0.4", &body)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to push metrics %v - %v", url, err), "url", url, "error", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Warn(fmt.Sprintf("Failed to push metrics %v - %v", url, resp.Status), "url", url, "status", resp.Status)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"strings"
+	"time"
+)
+
+var (
+	// targetRetention is the Object Lock retention mode of the resulting object, or empty
+	targetRetention minio.RetentionMode
+	// retainUntil is the time until which the resulting object is retained
+	retainUntil time.Time
+	// retentionPeriod is the retention-until period relative to the time the resulting object is written, or 0
+	retentionPeriod time.Duration
+)
+
+// Parse retention-mode and retention-until, an RFC 3339 time or a period such as 720h
+func parseRetention() error {
+	if retentionMode == "" {
+		if retentionUntil != "" {
+			return errors.New("retention-until requires retention-mode")
+		}
+		return nil
+	}
+	targetRetention = minio.RetentionMode(strings.ToUpper(retentionMode))
+	if !targetRetention.IsValid() {
+		return errors.New("retention-mode must be GOVERNANCE or COMPLIANCE")
+	}
+	if retentionUntil == "" {
+		return errors.New("retention-mode requires retention-until")
+	}
+	var err error
+	if retentionPeriod, err = time.ParseDuration(retentionUntil); err == nil {
+		if retentionPeriod <= 0 {
+			return errors.New("retention-until must be a positive period")
+		}
+		return nil
+	}
+	if retainUntil, err = time.Parse(time.RFC3339, retentionUntil); err != nil {
+		return errors.New("retention-until must be an RFC 3339 time or a period, e.g. 720h")
+	}
+	if !retainUntil.After(time.Now()) {
+		return errors.New("retention-until must be in the future")
+	}
+	return nil
+}
+
+// Return the time until which a resulting object written now is retained
+func retainUntilDate() time.Time {
+	if retentionPeriod > 0 {
+		return time.Now().Add(retentionPeriod).UTC()
+	}
+	return retainUntil
+}
+
+// Return the legal hold status of the resulting object, or empty
+func targetLegalHold() minio.LegalHoldStatus {
+	if legalHold {
+		return minio.LegalHoldEnabled
+	}
+	return ""
+}
+
+// Protect the resulting object written with opts with retention-mode and legal-hold. Objects written under a
+// temporary name are left unprotected so that they can be removed once copied into place.
+func retainPut(opts *minio.PutObjectOptions, temporary bool) {
+	if temporary {
+		return
+	}
+	if targetRetention != "" {
+		opts.Mode, opts.RetainUntilDate = targetRetention, retainUntilDate()
+	}
+	opts.LegalHold = targetLegalHold()
+}
+
+// Protect the resulting object copied with dst with retention-mode and legal-hold, unless it is temporary
+func retainCopy(dst *minio.CopyDestOptions, temporary bool) {
+	if temporary {
+		return
+	}
+	if targetRetention != "" {
+		dst.Mode, dst.RetainUntilDate = targetRetention, retainUntilDate()
+	}
+	dst.LegalHold = targetLegalHold()
+}
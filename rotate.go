@@ -0,0 +1,144 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// rotation completes the resulting object written so far and returns the writer of the next one
+type rotation func() (io.Writer, error)
+
+// objectUpload is the upload of a resulting object from the contents written to w
+type objectUpload struct {
+	w    *io.PipeWriter
+	done chan error
+}
+
+// Start uploading the resulting object targetObjectName, hashing its contents when it is named by hash or
+// checksummed with output-checksum
+func startUpload(ctx context.Context, target sink) *objectUpload {
+	reader, writer := io.Pipe()
+	u := &objectUpload{w: writer, done: make(chan error, 1)}
+	go func() {
+		var r io.Reader = reader
+		h, c := sha256.New(), crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		hashed := nameHashed || outputChecksum
+		if hashed {
+			r = io.TeeReader(reader, h)
+		}
+		if outputCRC32C {
+			r = io.TeeReader(r, c)
+		}
+		// The checksums are final once the contents are read, before the upload completes with their metadata
+		r = &eofReader{r: r, eof: func() {
+			if hashed {
+				contentHash = hex.EncodeToString(h.Sum(nil))
+			}
+			if outputCRC32C {
+				contentCRC32C = hex.EncodeToString(c.Sum(nil))
+			}
+		}}
+		counter := &countingWriter{w: io.Discard}
+		start := time.Now()
+		ctx, span := startSpan(ctx, "put", "object", targetObjectName)
+		err := target.upload(ctx, io.TeeReader(r, counter))
+		observe("put", time.Since(start))
+		span.end(err)
+		bytesUploaded.Add(counter.n)
+		if err != nil {
+			reader.CloseWithError(err)
+		} else {
+			stagedSize = counter.n
+		}
+		u.done <- err
+	}()
+	return u
+}
+
+// eofReader calls eof once r is read to its end
+type eofReader struct {
+	r    io.Reader
+	eof  func()
+	done bool
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF && !e.done {
+		e.done = true
+		e.eof()
+	}
+	return n, err
+}
+
+// Log the checksums of the resulting object computed with output-checksum
+func logChecksum() {
+	if !outputChecksum {
+		return
+	}
+	if outputCRC32C {
+		log.Printf("Checksums of %s: sha256 %s, crc32c %s\n", targetObjectName, contentHash, contentCRC32C)
+		return
+	}
+	log.Printf("Checksum of %s: sha256 %s\n", targetObjectName, contentHash)
+}
+
+// Complete the contents of the resulting object, waiting for its upload to finish
+func (u *objectUpload) finish() error {
+	u.w.Close()
+	return <-u.done
+}
+
+// Complete a resulting object of a rotated run other than the last, recording the objects appended to it as
+// the watermark of a capped run, so that a failure of the next resulting object does not append them again
+func completeTarget(ctx context.Context, target sink) error {
+	if err := nameTarget(ctx, target); err != nil {
+		return err
+	}
+	if incremental {
+		latest, latestKey := latestModified()
+		if err := saveWatermark(ctx, target, nextWatermark(latest, latestKey, lastObject())); err != nil {
+			slog.Error(fmt.Sprintf("Failed to save watermark %v - %v", stateObjectName(WatermarkName), err), "object", stateObjectName(WatermarkName), "error", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// Start the next resulting object of a rotated or sharded run
+func startTarget(ctx context.Context, target sink) error {
+	targetSeq++
+	objectCount, objectSize = 0, 0
+	positions.Lock()
+	positions.list, positions.first = nil, ""
+	positions.Unlock()
+	contentHash, contentCRC32C, stagedSize, stagedVerified = "", "", -1, false
+	resetMetadata()
+	resetManifest()
+	targetObjectName = newTargetObjectName(runStart)
+	return claimTargetName(ctx, target)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Run an append on every tick of the cron schedule, until ctx is done.
+// Runs never overlap: ticks falling while a run is in progress are skipped.
+func scheduleRuns(ctx context.Context, src source, target sink) {
+	for {
+		next := cronSchedule.Next(time.Now())
+		if scheduleJitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(scheduleJitter))))
+		}
+		log.Println("Next run at:", next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		if err := runOnce(ctx, src, target); err != nil && !errors.Is(err, errInputsUnchanged) {
+			slog.Error(fmt.Sprintf("Failed run started at %v - %v", start, err), "start", start, "error", err.Error())
+			alertFailure(start, err)
+		}
+		if missed := cronSchedule.Next(start); missed.Before(time.Now()) {
+			slog.Warn(fmt.Sprint("Skipping runs scheduled during the previous run, from: ", missed), "from", missed)
+		}
+	}
+}
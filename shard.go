@@ -0,0 +1,113 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"hash/fnv"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// shard-by values, distributing the source objects across the shards
+const (
+	// RoundRobinShards appends the source objects to each shard in turn, in listing order
+	RoundRobinShards = "round-robin"
+	// KeyHashShards appends each source object to the shard of the hash of its key, so that a key always
+	// lands in the same shard
+	KeyHashShards = "key-hash"
+)
+
+// Return the shard, from 0, of the i-th source object listed
+func shardOf(object minio.ObjectInfo, i int) int {
+	if shardBy == KeyHashShards {
+		h := fnv.New32a()
+		h.Write([]byte(object.Key))
+		return int(h.Sum32() % uint32(shards))
+	}
+	return i % int(shards)
+}
+
+// Return whether the source object falls in the share of the key space of this worker, by the hash of its key.
+// The hash differs from the one of key-hash shards, so that the shards of each worker remain balanced.
+func ownedByWorker(key string) bool {
+	if workerCount <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()%uint64(workerCount) == uint64(workerIndex)
+}
+
+// Return the suffix of the resulting objects and state objects of this worker, or empty without workers
+func workerSuffix() string {
+	if workerCount <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("worker-%04d", workerIndex)
+}
+
+// Append the source objects into shards resulting objects, one after the other, from a snapshot of the listing.
+// Shards receiving no source objects are not written.
+func runShards(ctx context.Context, src source, target sink) error {
+	groups := make([][]minio.ObjectInfo, shards)
+	i := 0
+	for object := range listSourceObjects(ctx, src, "", true) {
+		if object.Err != nil {
+			slog.Error(fmt.Sprintf("Failed to list: %v - %v", object.Key, object.Err), "key", object.Key, "error", object.Err.Error())
+			return object.Err
+		}
+		k := shardOf(object, i)
+		groups[k] = append(groups[k], object)
+		i++
+	}
+	if i == 0 {
+		slog.Error("Failed to find objects - exiting")
+		return errNoObjects
+	}
+
+	for k, objects := range groups {
+		if len(objects) == 0 {
+			continue
+		}
+		targetShard = k + 1
+		if err := startTarget(ctx, target); err != nil {
+			return err
+		}
+		log.Printf("Appending %v objects to shard %v of %v\n", len(objects), targetShard, shards)
+		err := streamObject(ctx, target, func(w io.Writer, rotate rotation) error {
+			queue := make(chan minio.ObjectInfo, len(objects))
+			for _, object := range objects {
+				queue <- object
+			}
+			close(queue)
+			return appendObjects(ctx, src, queue, w, nil)
+		})
+		if err == nil {
+			err = nameTarget(ctx, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	finishRun(ctx, target)
+	return nil
+}
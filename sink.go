@@ -0,0 +1,215 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"strings"
+)
+
+// sink is the destination of the single resulting object, also keeping the state objects recorded between runs
+type sink interface {
+	// upload writes the contents of r, of unknown length, as the resulting object targetObjectName
+	upload(ctx context.Context, r io.Reader) error
+	// rename renames the resulting object from to to
+	rename(ctx context.Context, from, to string) error
+	// exists returns whether the object name exists
+	exists(ctx context.Context, name string) (bool, error)
+	// open returns the contents of the object name
+	open(ctx context.Context, name string) (io.ReadCloser, error)
+	// list sends the objects whose name starts with prefix, with their size and time of modification.
+	// A listing error is sent as an object with Err set.
+	list(ctx context.Context, prefix string) <-chan minio.ObjectInfo
+	// getState returns the contents of the state object name, or nil if there is none
+	getState(ctx context.Context, name string) ([]byte, error)
+	// putState writes the contents of the state object name
+	putState(ctx context.Context, name string, data []byte) error
+	// createState writes the contents of the state object name unless it exists, returning whether it was written
+	createState(ctx context.Context, name string, data []byte) (bool, error)
+	// getStateVersion returns the contents of the state object name and the version read, or nil if there is none
+	getStateVersion(ctx context.Context, name string) ([]byte, string, error)
+	// replaceState writes the contents of the state object name if it is still at version, returning whether
+	// it was written
+	replaceState(ctx context.Context, name string, data []byte, version string) (bool, error)
+	// removeState removes the state object name
+	removeState(ctx context.Context, name string) error
+}
+
+// Metadata of the checksums of the resulting object with output-checksum and output-crc32c
+const (
+	SHA256Metadata = "Object-Appender-Sha256"
+	CRC32CMetadata = "Object-Appender-Crc32c"
+)
+
+// RunIDMetadata is the user metadata of the resulting object recording the ID of the run creating it
+const RunIDMetadata = "Object-Appender-Run-Id"
+
+// Return the user metadata of the resulting object: the source object metadata preserved, overridden by
+// target-metadata, then the provenance and checksums of the object
+func targetMetadata() map[string]string {
+	metadata := preservedMetadata()
+	for name, value := range extraMetadata {
+		metadata[name] = value
+	}
+	if provenance {
+		for name, value := range provenanceMetadata() {
+			metadata[name] = value
+		}
+	}
+	if outputChecksum && contentHash != "" {
+		metadata[SHA256Metadata] = contentHash
+	}
+	if outputCRC32C && contentCRC32C != "" {
+		metadata[CRC32CMetadata] = contentCRC32C
+	}
+	metadata[RunIDMetadata] = runID
+	return metadata
+}
+
+// Return the metadata set on the resulting object when it is copied server-side, replacing that of its source
+func copyMetadata() map[string]string {
+	metadata := targetMetadata()
+	metadata["Content-Type"] = contentType()
+	if storageClass != "" {
+		metadata["X-Amz-Storage-Class"] = storageClass
+	}
+	if encoding := contentEncoding(); encoding != "" {
+		metadata["Content-Encoding"] = encoding
+	}
+	return metadata
+}
+
+// Target schemes of target-bucket-prefix, selecting the sink driver
+const (
+	S3Scheme    = "s3://"
+	GCSScheme   = "gs://"
+	AzureScheme = "azure://"
+)
+
+// GCSEndpoint is the s3 interoperable endpoint of Google Cloud Storage
+const GCSEndpoint = "storage.googleapis.com"
+
+// Return the scheme of the target, stripping it from target-bucket-prefix
+func parseTargetScheme() string {
+	for _, scheme := range []string{S3Scheme, GCSScheme, AzureScheme} {
+		if strings.HasPrefix(targetBucketPrefix, scheme) {
+			targetBucketPrefix = strings.TrimPrefix(targetBucketPrefix, scheme)
+			return scheme
+		}
+	}
+	return S3Scheme
+}
+
+// s3Sink uploads the resulting object to an s3 bucket
+type s3Sink struct {
+	client *minio.Client
+}
+
+func (s *s3Sink) upload(ctx context.Context, r io.Reader) error {
+	return uploadObject(ctx, s.client, r)
+}
+
+func (s *s3Sink) rename(ctx context.Context, from, to string) error {
+	// Copying by compose is not bounded by the 5 GiB limit of a single copy
+	dst := minio.CopyDestOptions{Bucket: targetBucket, Object: to, UserMetadata: copyMetadata(), ReplaceMetadata: true, UserTags: targetTags, ReplaceTags: true, Encryption: targetEncryption}
+	retainCopy(&dst, false)
+	src := minio.CopySrcOptions{Bucket: targetBucket, Object: from, Encryption: targetReadEncryption()}
+	if ifNoneMatch != "" {
+		if err := composeIfNoneMatch(ctx, s.client, dst, src); err != nil {
+			return err
+		}
+	} else if _, err := s.client.ComposeObject(ctx, dst, src); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, targetBucket, from, minio.RemoveObjectOptions{})
+}
+
+func (s *s3Sink) exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.client.StatObject(ctx, targetBucket, name, minio.StatObjectOptions{ServerSideEncryption: targetReadEncryption()})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Sink) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, targetBucket, name, minio.GetObjectOptions{ServerSideEncryption: targetReadEncryption()})
+}
+
+func (s *s3Sink) list(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	return s.client.ListObjects(ctx, targetBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+}
+
+func (s *s3Sink) getState(ctx context.Context, name string) ([]byte, error) {
+	data, _, err := s.getStateVersion(ctx, name)
+	return data, err
+}
+
+// The version of a state object is its ETag
+func (s *s3Sink) getStateVersion(ctx context.Context, name string) ([]byte, string, error) {
+	obj, err := s.client.GetObject(ctx, targetBucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(obj)
+	}
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	return data, info.ETag, nil
+}
+
+func (s *s3Sink) putState(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, targetBucket, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+func (s *s3Sink) createState(ctx context.Context, name string, data []byte) (bool, error) {
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	ifNoneMatchAny(&opts)
+	_, err := s.client.PutObject(ctx, targetBucket, name, bytes.NewReader(data), int64(len(data)), opts)
+	if preconditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Sink) replaceState(ctx context.Context, name string, data []byte, version string) (bool, error) {
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	opts.SetMatchETag(version)
+	_, err := s.client.PutObject(ctx, targetBucket, name, bytes.NewReader(data), int64(len(data)), opts)
+	// The state object was replaced or removed since it was read
+	if preconditionFailed(err) || minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Sink) removeState(ctx context.Context, name string) error {
+	return s.client.RemoveObject(ctx, targetBucket, name, minio.RemoveObjectOptions{})
+}
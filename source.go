@@ -0,0 +1,197 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// source is the origin of the objects to append
+type source interface {
+	// list sends the objects under the source prefix in key order, starting after startAfter when set.
+	// A listing error is sent as an object with Err set.
+	list(ctx context.Context, startAfter string) <-chan minio.ObjectInfo
+	// stat returns the object key
+	stat(ctx context.Context, key string) (minio.ObjectInfo, error)
+	// open returns the contents of the object key from offset
+	open(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+	// tags returns the tags of the object key
+	tags(ctx context.Context, key string) (map[string]string, error)
+	// metadata returns the user metadata of the object key
+	metadata(ctx context.Context, key string) (map[string]string, error)
+}
+
+// Return whether the source objects are read from an s3 bucket
+func isS3Source(src source) bool {
+	switch s := src.(type) {
+	case *s3Source, *inventorySource:
+		return true
+	case *keyListSource:
+		return isS3Source(s.source)
+	case *orderedSource:
+		return isS3Source(s.source)
+	}
+	return false
+}
+
+// FileScheme is the source scheme of source-bucket-prefix selecting the local filesystem driver
+const FileScheme = "file://"
+
+// s3Source reads the source objects from an s3 bucket
+type s3Source struct {
+	client *minio.Client
+}
+
+func (s *s3Source) list(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	opts := minio.ListObjectsOptions{
+		Recursive:  true,
+		Prefix:     sourcePrefix,
+		StartAfter: startAfter,
+		// Where supported, avoid fetching the metadata of each object
+		WithMetadata: len(metadataFilters) > 0,
+	}
+	// List all objects from a bucket-name with a matching prefix.
+	return s.client.ListObjects(ctx, sourceBucket, opts)
+}
+
+func (s *s3Source) stat(ctx context.Context, key string) (minio.ObjectInfo, error) {
+	return s.client.StatObject(ctx, sourceBucket, key, minio.StatObjectOptions{ServerSideEncryption: sourceEncryption})
+}
+
+func (s *s3Source) open(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{ServerSideEncryption: sourceEncryption}
+	if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, err
+		}
+	}
+	return s.client.GetObject(ctx, sourceBucket /*bucketName*/, key /*objectName*/, opts)
+}
+
+func (s *s3Source) tags(ctx context.Context, key string) (map[string]string, error) {
+	t, err := s.client.GetObjectTagging(ctx, sourceBucket, key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return t.ToMap(), nil
+}
+
+func (s *s3Source) metadata(ctx context.Context, key string) (map[string]string, error) {
+	object, err := s.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return object.UserMetadata, nil
+}
+
+// fileSource reads the source objects from the files under a local directory, keyed by their slash-separated
+// path relative to the directory
+type fileSource struct {
+	root string
+}
+
+// Return the local filesystem source of a file:///path/dir source-bucket-prefix, naming the source bucket
+// after the directory
+func newFileSource(sourceURL string) *fileSource {
+	root := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(sourceURL, FileScheme)))
+	sourceBucket, sourcePrefix = filepath.Base(root), ""
+	return &fileSource{root: root}
+}
+
+func (s *fileSource) list(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		var keys []string
+		err := filepath.WalkDir(s.root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(s.root, name)
+			if err != nil {
+				return err
+			}
+			if key := filepath.ToSlash(rel); key > startAfter {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			objects <- minio.ObjectInfo{Key: s.root, Err: err}
+			return
+		}
+
+		// Files are walked per directory, list them in key order across directories
+		sort.Strings(keys)
+		for _, key := range keys {
+			object, err := s.stat(ctx, key)
+			if err != nil {
+				object.Err = err
+			}
+			select {
+			case objects <- object:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return objects
+}
+
+func (s *fileSource) stat(ctx context.Context, key string) (minio.ObjectInfo, error) {
+	info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(key)))
+	if err != nil {
+		return minio.ObjectInfo{Key: key}, err
+	}
+	return minio.ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime().UTC()}, nil
+}
+
+func (s *fileSource) open(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(key)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Files have no tags
+func (s *fileSource) tags(ctx context.Context, key string) (map[string]string, error) {
+	return nil, nil
+}
+
+// Files have no user metadata
+func (s *fileSource) metadata(ctx context.Context, key string) (map[string]string, error) {
+	return nil, nil
+}
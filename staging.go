@@ -0,0 +1,108 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// StagingPrefix is the prefix under the target prefix of the resulting objects uploaded under a temporary name
+	StagingPrefix = ".staging"
+	// StagingMaxAge is the age after which a staged object is abandoned, its run having failed
+	StagingMaxAge = 24 * time.Hour
+)
+
+var (
+	// stagedSize is the size of the resulting object uploaded, or -1 when it was not uploaded through the client
+	stagedSize int64 = -1
+	// stagedVerified is whether the staged resulting object was verified complete before it was put in place
+	stagedVerified bool
+)
+
+// Return the temporary name the resulting object of the run is uploaded under
+func stagingName() string {
+	return targetPrefix + "/" + StagingPrefix + "/" + runID + outputExtension()
+}
+
+// Check that the staged resulting object is complete before it is put in place, as consumers must never see a
+// partially written object
+func verifyStaged(ctx context.Context, target sink) error {
+	if !staging {
+		return nil
+	}
+	if err := verifySize(ctx, target, targetObjectName); err != nil {
+		return err
+	}
+	stagedVerified = true
+	return nil
+}
+
+// Return the size the resulting object must have: the size uploaded, or that of the source objects composed on
+// the server
+func expectedSize() int64 {
+	if stagedSize < 0 {
+		return objectSize
+	}
+	return stagedSize
+}
+
+// Check that the object name of the target has the expected size of the resulting object
+func verifySize(ctx context.Context, target sink, name string) error {
+	for object := range target.list(ctx, name) {
+		if object.Err != nil {
+			slog.Error(fmt.Sprintf("Failed to list: %v - %v", name, object.Err), "object", name, "error", object.Err.Error())
+			return object.Err
+		}
+		if strings.TrimPrefix(object.Key, "/") != strings.TrimPrefix(name, "/") {
+			continue
+		}
+		if object.Size != expectedSize() {
+			err := fmt.Errorf("object %v is %v bytes, expected %v", name, object.Size, expectedSize())
+			slog.Error(fmt.Sprintf("Failed to verify object %v - %v", name, err), "object", name, "error", err.Error())
+			return err
+		}
+		return nil
+	}
+	err := fmt.Errorf("object %v not found", name)
+	slog.Error(fmt.Sprintf("Failed to verify object %v - %v", name, err), "object", name, "error", err.Error())
+	return err
+}
+
+// Remove the staged objects abandoned by failed runs
+func collectStaging(ctx context.Context, target sink) {
+	prefix := targetPrefix + "/" + StagingPrefix + "/"
+	for object := range target.list(ctx, prefix) {
+		if object.Err != nil {
+			slog.Warn(fmt.Sprintf("Failed to list: %v - %v", prefix, object.Err), "prefix", prefix, "error", object.Err.Error())
+			return
+		}
+		if time.Since(object.LastModified) < StagingMaxAge {
+			continue
+		}
+		log.Printf("Removing abandoned staged object %s\n", object.Key)
+		if err := target.removeState(ctx, object.Key); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to remove object %v - %v", object.Key, err), "key", object.Key, "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsdPrefix prefixes the names of the metrics emitted to StatsD
+const StatsdPrefix = "object_appender."
+
+// statsd is the connection to the StatsD agent of statsd-addr, with the counters emitted so far
+var statsd struct {
+	sync.Mutex
+	conn net.Conn
+	sent map[string]int64
+}
+
+// Connect to the StatsD or DogStatsD agent of statsd-addr
+func configureStatsd() error {
+	if statsdAddr == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", statsdAddr)
+	if err != nil {
+		return err
+	}
+	statsd.conn, statsd.sent = conn, map[string]int64{}
+	return nil
+}
+
+// Send a metric in the DogStatsD format, tagged with the source bucket and prefix
+func sendStat(name string, value interface{}, kind string) {
+	if statsd.conn == nil {
+		return
+	}
+	escape := strings.NewReplacer(",", "_", "|", "_", "#", "_")
+	tags := "source_bucket:" + escape.Replace(sourceBucket) + ",source_prefix:" + escape.Replace(sourcePrefix)
+	if _, err := fmt.Fprintf(statsd.conn, "%s%s:%v|%s|#%s", StatsdPrefix, name, value, kind, tags); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to send metric %v to %v - %v", name, statsdAddr, err), "metric", name, "addr", statsdAddr, "error", err.Error())
+	}
+}
+
+// Send the latency of an operation as a timing in milliseconds
+func sendTiming(operation string, d time.Duration) {
+	sendStat(operation+".duration", float64(d.Microseconds())/1000, "ms")
+}
+
+// Send the counters of the run ending with err, as increments since the previous run
+func sendRunStats(err error) {
+	if statsd.conn == nil {
+		return
+	}
+	statsd.Lock()
+	defer statsd.Unlock()
+	for name, value := range map[string]int64{
+		"objects.listed":   objectsListed.Load(),
+		"objects.appended": objectsAppended.Load(),
+		"objects.skipped":  objectsSkipped.Load(),
+		"objects.failed":   objectsFailed.Load(),
+		"bytes.downloaded": bytesDownloaded.Load(),
+		"bytes.uploaded":   bytesUploaded.Load(),
+	} {
+		sendStat(name, value-statsd.sent[name], "c")
+		statsd.sent[name] = value
+	}
+	if err != nil {
+		sendStat("runs.failed", 1, "c")
+	} else {
+		sendStat("runs.succeeded", 1, "c")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// skippedKey is a source object listed but not appended, with the filter not selecting it
+type skippedKey struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// runSummary is the summary of a run written with output-json
+type runSummary struct {
+	RunID   string       `json:"runId"`
+	Targets []string     `json:"targets"`
+	Matched []string     `json:"matched"`
+	Skipped []skippedKey `json:"skipped"`
+	Objects int64        `json:"objects"`
+	// Bytes is the size of the source objects appended
+	Bytes int64     `json:"bytes"`
+	Start time.Time `json:"start"`
+	// Duration is the duration of the run in seconds
+	Duration float64  `json:"duration"`
+	Errors   []string `json:"errors"`
+}
+
+// summary is the summary of the current run, recorded with output-json
+var summary struct {
+	sync.Mutex
+	runSummary
+}
+
+// Forget the summary of any previous run
+func resetSummary() {
+	summary.Lock()
+	summary.runSummary = runSummary{Targets: []string{}, Matched: []string{}, Skipped: []skippedKey{}, Errors: []string{}}
+	summary.Unlock()
+}
+
+// Return whether the summary of the run is recorded, for output-json, the termination message in kubernetes mode,
+// the audit record or notify-url
+func summarizing() bool {
+	return outputJSON != "" || kubernetes || audit || notifyURL != ""
+}
+
+// Record that the source object listed is not appended for reason
+func recordSkip(key, reason string) {
+	objectsSkipped.Add(1)
+	if !summarizing() {
+		return
+	}
+	summary.Lock()
+	summary.Skipped = append(summary.Skipped, skippedKey{Key: key, Reason: reason})
+	summary.Unlock()
+}
+
+// Record that the source object is appended
+func recordMatch(key string) {
+	objectsAppended.Add(1)
+	if !summarizing() {
+		return
+	}
+	summary.Lock()
+	summary.Matched = append(summary.Matched, key)
+	summary.Unlock()
+}
+
+// Record that the resulting object name was written, with the source objects appended to it
+func recordTarget(name string) {
+	if !summarizing() {
+		return
+	}
+	summary.Lock()
+	summary.Targets = append(summary.Targets, name)
+	summary.Bytes += objectSize
+	summary.Unlock()
+}
+
+// Return the summary of the run ending with err
+func summarize(err error) runSummary {
+	summary.Lock()
+	s := summary.runSummary
+	summary.Unlock()
+	s.RunID, s.Objects, s.Start = runID, int64(len(s.Matched)), runStart
+	s.Duration = time.Since(runStart).Seconds()
+	if err != nil {
+		s.Errors = append(s.Errors, err.Error())
+	}
+	return s
+}
+
+// Write the summary of the run ending with err to output-json, a file or - for stdout
+func writeSummary(err error) {
+	if outputJSON == "" {
+		return
+	}
+	data, err := json.Marshal(summarize(err))
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to write summary %v - %v", outputJSON, err), "file", outputJSON, "error", err.Error())
+		return
+	}
+	data = append(data, '\n')
+	if outputJSON == StdoutOutput {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(outputJSON, data, 0o644)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to write summary %v - %v", outputJSON, err), "file", outputJSON, "error", err.Error())
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxPendingSpans is the number of ended spans triggering their export before the end of the run
+const MaxPendingSpans = 1000
+
+// OTLPProtocol is the only OTEL_EXPORTER_OTLP_PROTOCOL spans are exported with, also when it is unset
+const OTLPProtocol = "http/json"
+
+// span is an operation traced with OpenTelemetry
+type span struct {
+	traceID, spanID, parentID string
+	name                      string
+	start                     time.Time
+	attributes                []string
+}
+
+type spanKey struct{}
+
+// The configuration of the OTLP exporter, read from the standard OTEL_* environment variables
+var tracer struct {
+	endpoint string
+	header   http.Header
+	resource []otlpAttribute
+
+	sync.Mutex
+	pending []otlpSpan
+}
+
+// otlpAttribute is a string attribute in the OTLP/HTTP JSON encoding
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// otlpSpan is a span in the OTLP/HTTP JSON encoding, where IDs are hex and times Unix nanoseconds
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// Return the attributes of the key and value pairs
+func otlpAttributes(pairs []string) []otlpAttribute {
+	attributes := make([]otlpAttribute, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		a := otlpAttribute{Key: pairs[i]}
+		a.Value.StringValue = pairs[i+1]
+		attributes = append(attributes, a)
+	}
+	return attributes
+}
+
+// Return the key=value pairs, separated by commas and URL-encoded, of an OTEL_* environment variable
+func otelPairs(value string) []string {
+	var pairs []string
+	for _, pair := range strings.Split(value, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		pairs = append(pairs, strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return pairs
+}
+
+// Configure tracing from OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, exporting spans with
+// OTLP over HTTP in JSON. Tracing is off when neither is set, or with OTEL_SDK_DISABLED or OTEL_TRACES_EXPORTER=none.
+// Other protocols, http/protobuf and grpc, are not spoken and fail the configuration, rather than export nothing.
+func configureTracing() error {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil
+	}
+	tracer.endpoint = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if tracer.endpoint == "" {
+		if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+			tracer.endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+		}
+	}
+	if tracer.endpoint == "" {
+		return nil
+	}
+	protocol := firstEnv([]string{"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"})
+	if protocol != "" && protocol != OTLPProtocol {
+		tracer.endpoint = ""
+		return fmt.Errorf("OTLP protocol %v is not supported, only %v", protocol, OTLPProtocol)
+	}
+
+	tracer.header = http.Header{"Content-Type": {"application/json"}}
+	headers := otelPairs(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	headers = append(headers, otelPairs(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"))...)
+	for i := 0; i < len(headers); i += 2 {
+		tracer.header.Set(headers[i], headers[i+1])
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "object-appender"
+	}
+	resource := append(otelPairs(os.Getenv("OTEL_RESOURCE_ATTRIBUTES")), "service.name", service, "service.version", toolVersion())
+	tracer.resource = otlpAttributes(resource)
+	return nil
+}
+
+// Return a random hex ID of n bytes
+func randomID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Start a span of the operation name, child of the span of ctx if any, with the given key and value attributes.
+// Returns ctx unchanged and a nil span, which ends as a no-op, when tracing is off.
+func startSpan(ctx context.Context, name string, attributes ...string) (context.Context, *span) {
+	if tracer.endpoint == "" {
+		return ctx, nil
+	}
+	s := &span{spanID: randomID(8), name: name, start: time.Now(), attributes: attributes}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// End the span of an operation failing with err, if any
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	o := otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       s.spanID,
+		ParentSpanID: s.parentID,
+		Name:         s.name,
+		// Root spans are internal, the others being calls to the source or target
+		Kind:       1,
+		Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+		End:        strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes: otlpAttributes(append(s.attributes, "run.id", runID)),
+	}
+	if s.parentID != "" {
+		o.Kind = 3
+	}
+	if err != nil {
+		o.Status.Code, o.Status.Message = 2, err.Error()
+	}
+	tracer.Lock()
+	tracer.pending = append(tracer.pending, o)
+	full := len(tracer.pending) >= MaxPendingSpans
+	tracer.Unlock()
+	if full {
+		go exportSpans()
+	}
+}
+
+// Export the spans ended so far
+func exportSpans() {
+	tracer.Lock()
+	spans := tracer.pending
+	tracer.pending = nil
+	tracer.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	type scopeSpans struct {
+		Scope struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	type resourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	rs := resourceSpans{}
+	rs.Resource.Attributes = tracer.resource
+	ss := scopeSpans{Spans: spans}
+	ss.Scope.Name, ss.Scope.Version = "object-appender", toolVersion()
+	rs.ScopeSpans = []scopeSpans{ss}
+	data, err := json.Marshal(struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}{[]resourceSpans{rs}})
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to export spans %v - %v", tracer.endpoint, err), "url", tracer.endpoint, "error", err.Error())
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, tracer.endpoint, bytes.NewReader(data))
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to export spans %v - %v", tracer.endpoint, err), "url", tracer.endpoint, "error", err.Error())
+		return
+	}
+	req.Header = tracer.header.Clone()
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to export spans %v - %v", tracer.endpoint, err), "url", tracer.endpoint, "error", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Warn(fmt.Sprintf("Failed to export spans %v - %v", tracer.endpoint, resp.Status), "url", tracer.endpoint, "status", resp.Status)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"golang.org/x/net/http/httpproxy"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Create the http transport used to connect to the s3 and STS endpoints, applying the proxy and TLS settings.
+// Requests go through the proxy given, or otherwise HTTP_PROXY/HTTPS_PROXY, except for hosts in NO_PROXY.
+func newTransport() (*http.Transport, error) {
+	tr, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != "" {
+		if _, err := url.Parse(proxy); err != nil {
+			return nil, err
+		}
+		proxyFunc := (&httpproxy.Config{
+			HTTPProxy:  proxy,
+			HTTPSProxy: proxy,
+			NoProxy:    firstEnv([]string{"NO_PROXY", "no_proxy"}),
+		}).ProxyFunc()
+		tr.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+	if !secure {
+		return tr, nil
+	}
+	if tr.TLSClientConfig == nil {
+		tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tr.TLSClientConfig.InsecureSkipVerify = insecure
+
+	// Trust the custom CA certificates in addition to the system ones
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caCert)
+		}
+		tr.TLSClientConfig.RootCAs = pool
+	}
+
+	// Authenticate with a client certificate for mutual TLS
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, errors.New("client-cert and client-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tr, nil
+}
+
+// quotedWildcard is the If-None-Match header minio-go sets for SetMatchETagExcept("*"), which s3 compares
+// as an ETag rather than as the * wildcard
+const quotedWildcard = `"*"`
+
+// signingTransport sends the headers that minio-go does not let requests carry, signing the requests again as
+// the headers are signed: If-None-Match as the bare * wildcard for conditional writes, and the headers attached to
+// the context of part uploads with withPartHeaders
+type signingTransport struct {
+	http.RoundTripper
+	creds *credentials.Credentials
+}
+
+// partHeadersKey is the context key of the partHeaders of a part upload
+type partHeadersKey struct{}
+
+// partHeaders are the headers uploading a part, along with its contents, from which its chunks are signed again
+type partHeaders struct {
+	header http.Header
+	data   []byte
+}
+
+// Return ctx uploading a part of contents data with header, if any
+func withPartHeaders(ctx context.Context, header http.Header, data []byte) context.Context {
+	if header == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, partHeadersKey{}, &partHeaders{header, data})
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	part, _ := req.Context().Value(partHeadersKey{}).(*partHeaders)
+	if part != nil && (req.Method != http.MethodPut || !req.URL.Query().Has("partNumber")) {
+		part = nil
+	}
+	// The chunks of a streaming payload are signed from the signature of the request, so only parts, whose
+	// contents are known, are signed again in chunks
+	streaming := strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-")
+	wildcard := req.Header.Get("If-None-Match") == quotedWildcard && !streaming
+	if part == nil && !wildcard {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if wildcard {
+		req.Header.Set("If-None-Match", "*")
+	}
+	if part != nil {
+		for name, values := range part.header {
+			req.Header[name] = values
+		}
+	}
+	region, ok := signedRegion(req.Header.Get("Authorization"))
+	if !ok {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	value, err := t.creds.Get()
+	if err != nil {
+		return nil, err
+	}
+	if part != nil && streaming {
+		req.Body = io.NopCloser(bytes.NewReader(part.data))
+		req = signer.StreamingSignV4(req, value.AccessKeyID, value.SecretAccessKey, value.SessionToken, region,
+			int64(len(part.data)), time.Now().UTC(), sha256Hasher{sha256.New()})
+		return t.RoundTripper.RoundTrip(req)
+	}
+	return t.RoundTripper.RoundTrip(signer.SignV4(*req, value.AccessKeyID, value.SecretAccessKey, value.SessionToken, region))
+}
+
+// sha256Hasher is the SHA-256 hasher with which streaming payloads are signed
+type sha256Hasher struct {
+	hash.Hash
+}
+
+func (sha256Hasher) Close() {}
+
+// Return the region of the scope of a signature V4 Authorization header, or false if it is not one
+func signedRegion(authorization string) (string, bool) {
+	credential, ok := strings.CutPrefix(authorization, "AWS4-HMAC-SHA256 Credential=")
+	if !ok {
+		return "", false
+	}
+	credential, _, _ = strings.Cut(credential, ",")
+	// The scope is <access key>/<date>/<region>/s3/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) < 5 {
+		return "", false
+	}
+	return scope[len(scope)-3], true
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Return a client of an s3 endpoint going through signingTransport, passing the requests it receives with their
+// payload to handle, once their signature is checked
+func signingClient(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, payload []byte)) *minio.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := verifySignature(r, "secret")
+		if err != nil {
+			t.Errorf("%v %v: %v", r.Method, r.URL, err)
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		handle(w, r, payload)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := credentials.NewStaticV4("access", "secret", "")
+	client, err := minio.New(u.Host, &minio.Options{Creds: creds, Transport: &signingTransport{RoundTripper: http.DefaultTransport, creds: creds},
+		Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Check the signature V4 of the request by secretKey, and the signatures of the chunks of a streaming payload,
+// returning the payload
+func verifySignature(r *http.Request, secretKey string) ([]byte, error) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.ReplaceAll(strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 "), " ", ""), ",") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+	_, scope, _ := strings.Cut(fields["Credential"], "/")
+	var headers strings.Builder
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	canonical := strings.Join([]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery, headers.String(), fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256")}, "\n")
+	key := []byte("AWS4" + secretKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	date := r.Header.Get("X-Amz-Date")
+	signature := hex.EncodeToString(hmacSHA256(key, "AWS4-HMAC-SHA256\n"+date+"\n"+scope+"\n"+sha256Hex([]byte(canonical))))
+	if signature != fields["Signature"] {
+		return nil, errors.New("signature does not match")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil || r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return body, err
+	}
+
+	// Each chunk is <size>;chunk-signature=<signature>, signed from the signature before it
+	var payload []byte
+	br := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, chunkSignature, _ := strings.Cut(strings.TrimSpace(line), ";chunk-signature=")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, n+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		chunk = chunk[:n]
+		signature = hex.EncodeToString(hmacSHA256(key, "AWS4-HMAC-SHA256-PAYLOAD\n"+date+"\n"+scope+"\n"+signature+"\n"+sha256Hex(nil)+"\n"+sha256Hex(chunk)))
+		if signature != chunkSignature {
+			return nil, fmt.Errorf("signature of chunk of %v bytes does not match", n)
+		}
+		if n == 0 {
+			return payload, nil
+		}
+		payload = append(payload, chunk...)
+	}
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"hash"
+	"io"
+	"log"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// VerifyRetries is the number of times a source object held in memory is downloaded again when it fails to verify
+const VerifyRetries = 3
+
+// md5ETag matches the ETags that are the MD5 of the contents, as those of objects uploaded in a single part
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var errVerify = errors.New("source object failed to verify")
+
+// verifiedReader checks at the end of a source object that the bytes read match its listed size and, when its
+// ETag is the MD5 of its contents, its ETag
+type verifiedReader struct {
+	r      io.Reader
+	object minio.ObjectInfo
+	offset int64
+	n      int64
+	md5    hash.Hash
+}
+
+// Return r reading the source object from offset, verified unless no-verify
+func verifyObject(r io.Reader, object minio.ObjectInfo, offset int64) io.Reader {
+	if noVerify {
+		return r
+	}
+	v := &verifiedReader{r: r, object: object, offset: offset}
+	if offset == 0 && sourceEncryption == nil && md5ETag.MatchString(object.ETag) {
+		v.md5 = md5.New()
+	}
+	return v
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.n += int64(n)
+	if v.md5 != nil {
+		v.md5.Write(p[:n])
+	}
+	if err == io.EOF {
+		if verr := v.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+// Check the bytes read from the source object once all are read
+func (v *verifiedReader) verify() error {
+	if v.object.Size >= 0 && v.n != v.object.Size-v.offset {
+		return fmt.Errorf("%w: object %v read %v bytes, expected %v", errVerify, v.object.Key, v.n, v.object.Size-v.offset)
+	}
+	if v.md5 == nil {
+		return nil
+	}
+	if s, ok := v.r.(interface {
+		Stat() (minio.ObjectInfo, error)
+	}); ok {
+		// The ETag of objects encrypted with SSE-KMS or SSE-C is not their MD5
+		if info, err := s.Stat(); err == nil && (info.Metadata.Get("X-Amz-Server-Side-Encryption") == "aws:kms" ||
+			info.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "") {
+			return nil
+		}
+	}
+	if sum := hex.EncodeToString(v.md5.Sum(nil)); sum != v.object.ETag {
+		return fmt.Errorf("%w: object %v MD5 %v does not match ETag %v", errVerify, v.object.Key, sum, v.object.ETag)
+	}
+	return nil
+}
+
+// VerifyCommand is the subcommand verifying an existing resulting object instead of appending
+const VerifyCommand = "verify"
+
+var errTargetDiverged = errors.New("target object diverges from its source objects")
+
+// rangeHasher hashes the contents written through it, and the bytes of each source object of a manifest
+type rangeHasher struct {
+	whole   hash.Hash
+	entries []manifestEntry
+	sums    []hash.Hash
+	i       int
+	pos     int64
+}
+
+// Return a hasher of the whole contents and of the bytes of each of the entries, in increasing offsets
+func newRangeHasher(entries []manifestEntry) *rangeHasher {
+	h := &rangeHasher{whole: sha256.New(), entries: entries, sums: make([]hash.Hash, len(entries))}
+	for i := range h.sums {
+		h.sums[i] = sha256.New()
+	}
+	return h
+}
+
+func (h *rangeHasher) Write(p []byte) (int, error) {
+	h.whole.Write(p)
+	written := len(p)
+	for len(p) > 0 {
+		for h.i < len(h.entries) && h.pos >= h.entries[h.i].Offset+h.entries[h.i].Length {
+			h.i++
+		}
+		n := int64(len(p))
+		if h.i < len(h.entries) {
+			e := h.entries[h.i]
+			if h.pos < e.Offset {
+				n = min(n, e.Offset-h.pos)
+			} else {
+				n = min(n, e.Offset+e.Length-h.pos)
+				h.sums[h.i].Write(p[:n])
+			}
+		}
+		h.pos += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Verify the resulting object name by appending its source objects again, those of its manifest if it has one,
+// and comparing the contents derived with the object, and the positions of the source objects with the manifest.
+// Any divergence is logged.
+func verifyTarget(ctx context.Context, src source, target sink, name string) error {
+	startRun(time.Now().UTC())
+	targetObjectName = targetPrefix + "/" + strings.TrimPrefix(name, "/")
+
+	var recorded *manifestObject
+	data, err := target.getState(ctx, targetObjectName+ManifestExtension)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to read manifest %v - %v", targetObjectName+ManifestExtension, err), "object", targetObjectName+ManifestExtension, "error", err.Error())
+		return err
+	}
+	if data != nil {
+		recorded = &manifestObject{}
+		if err = json.Unmarshal(data, recorded); err != nil {
+			slog.Error(fmt.Sprintf("Failed to read manifest %v - %v", targetObjectName+ManifestExtension, err), "object", targetObjectName+ManifestExtension, "error", err.Error())
+			return err
+		}
+		keys := make([]string, 0, len(recorded.Objects))
+		for _, e := range recorded.Objects {
+			keys = append(keys, e.Key)
+		}
+		log.Printf("Verifying %s against the %v source objects of its manifest\n", targetObjectName, len(keys))
+		src = &keyListSource{source: src, keys: keys}
+		// Record the positions derived, to be compared with the manifest
+		writeManifest = true
+	} else {
+		log.Printf("Verifying %s against its source objects\n", targetObjectName)
+	}
+	var entries []manifestEntry
+	if recorded != nil {
+		entries = recorded.Objects
+	}
+
+	derived := newRangeHasher(entries)
+	if err = downloadObjects(ctx, src, derived, nil); err != nil {
+		return err
+	}
+	r, err := target.open(ctx, targetObjectName)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to read object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return err
+	}
+	defer r.Close()
+	actual := newRangeHasher(entries)
+	if _, err = io.Copy(actual, r); err != nil {
+		slog.Error(fmt.Sprintf("Failed to read object %v - %v", targetObjectName, err), "object", targetObjectName, "error", err.Error())
+		return err
+	}
+
+	diverged := false
+	if recorded != nil {
+		manifest.Lock()
+		appended := manifest.entries
+		manifest.Unlock()
+		if len(appended) != len(recorded.Objects) {
+			slog.Error(fmt.Sprintf("Appended %v source objects, recorded %v", len(appended), len(recorded.Objects)), "object", targetObjectName, "objects", len(appended), "recorded", len(recorded.Objects))
+			diverged = true
+		}
+		for i, e := range recorded.Objects {
+			if i >= len(appended) {
+				break
+			}
+			a := appended[i]
+			switch {
+			case a.ETag != e.ETag || a.Size != e.Size:
+				slog.Error(fmt.Sprintf("Source object %v changed: size %v, ETag %v, recorded size %v, ETag %v", e.Key, a.Size, a.ETag, e.Size, e.ETag), "key", e.Key, "bytes", a.Size, "etag", a.ETag)
+				diverged = true
+			case a.Offset != e.Offset || a.Length != e.Length:
+				slog.Error(fmt.Sprintf("Source object %v moved: offset %v, length %v, recorded offset %v, length %v", e.Key, a.Offset, a.Length, e.Offset, e.Length), "key", e.Key, "offset", a.Offset, "length", a.Length)
+				diverged = true
+			case !bytes.Equal(derived.sums[i].Sum(nil), actual.sums[i].Sum(nil)):
+				slog.Error(fmt.Sprintf("Source object %v diverges from bytes %v-%v of %v", e.Key, e.Offset, e.Offset+e.Length-1, targetObjectName), "key", e.Key, "object", targetObjectName, "offset", e.Offset, "length", e.Length)
+				diverged = true
+			}
+		}
+	}
+	derivedSum, actualSum := hex.EncodeToString(derived.whole.Sum(nil)), hex.EncodeToString(actual.whole.Sum(nil))
+	if derivedSum != actualSum {
+		slog.Error(fmt.Sprintf("Object %v sha256 %v, derived from source objects %v", targetObjectName, actualSum, derivedSum), "object", targetObjectName, "sha256", actualSum, "derived", derivedSum)
+		diverged = true
+	}
+	if diverged {
+		return errTargetDiverged
+	}
+	log.Printf("Successfully verified %s, sha256 %s\n", targetObjectName, actualSum)
+	return nil
+}
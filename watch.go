@@ -0,0 +1,237 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/notification"
+	"io"
+	"log"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// ObjectCreatedEvents are the bucket notification events subscribed to in watch mode
+var ObjectCreatedEvents = []string{"s3:ObjectCreated:*"}
+
+// StopFlushTimeout bounds the flush of the pending objects once watching is stopped, within the default
+// termination grace period of Kubernetes pods
+const StopFlushTimeout = 25 * time.Second
+
+// A failed flush is retried after FlushRetryDelay, doubling up to MaxFlushRetryDelay, keeping its objects pending
+const (
+	FlushRetryDelay    = 5 * time.Second
+	MaxFlushRetryDelay = 5 * time.Minute
+)
+
+// Continuously append source objects as they are created, flushing a new resulting object
+// once flushSize bytes are pending or flushInterval has elapsed since the first pending object, until ctx is done.
+// With rotate-interval, pending objects are instead flushed at the end of the window in which they were created,
+// into a resulting object named after the start of the window.
+func watchObjects(ctx context.Context, sourceClient *minio.Client, target sink) {
+	src := &s3Source{client: sourceClient}
+	log.Printf("Watching %s for new objects\n", sourceBucketPrefix)
+	events := sourceClient.ListenBucketNotification(ctx, sourceBucket, sourcePrefix, "", ObjectCreatedEvents)
+
+	var pending []minio.ObjectInfo
+	var pendingSize int64
+	var window time.Time
+	timer := time.NewTimer(flushInterval)
+	stopTimer(timer)
+
+	// Failed flushes are retried no earlier than retryAt
+	var retryAt time.Time
+	retryDelay := FlushRetryDelay
+
+	lastName, seq := "", 0
+	flush := func(ctx context.Context) {
+		stopTimer(timer)
+		if len(pending) == 0 {
+			return
+		}
+		objects := pending
+		pending, pendingSize = nil, 0
+		sortObjects(objects)
+
+		// Flushes within the same second are told apart by a suffix
+		now := time.Now().UTC()
+		if rotateInterval > 0 {
+			now = window
+		}
+		startRun(now)
+		name := newTargetObjectName(now)
+		if name == lastName {
+			seq++
+			targetObjectName = suffixName(name, seq)
+		} else {
+			targetObjectName, seq = name, 0
+		}
+		lastName = name
+		if err := flushObjects(ctx, src, target, objects); err != nil {
+			// Keep the objects pending until a flush succeeds
+			pending = objects
+			for _, object := range objects {
+				pendingSize += object.Size
+			}
+			slog.Warn(fmt.Sprintf("Retrying to flush %v objects in %v", len(objects), retryDelay), "objects", len(objects), "delay", retryDelay.Seconds())
+			retryAt = time.Now().Add(retryDelay)
+			timer.Reset(retryDelay)
+			retryDelay = min(2*retryDelay, MaxFlushRetryDelay)
+			return
+		}
+		retryAt, retryDelay = time.Time{}, FlushRetryDelay
+	}
+
+	// Once watching is stopped, the pending objects are flushed with a context of their own
+	stopFlush := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), StopFlushTimeout)
+		defer cancel()
+		flush(ctx)
+		if len(pending) > 0 {
+			slog.Error(fmt.Sprintf("Failed to flush %v objects before stopping, from: %v", len(pending), pending[0].Key), "objects", len(pending), "key", pending[0].Key)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			stopFlush()
+			return
+		case <-timer.C:
+			flush(ctx)
+		case info, ok := <-events:
+			if !ok {
+				stopFlush()
+				return
+			}
+			if info.Err != nil {
+				slog.Error(fmt.Sprintf("Failed to receive notification: %v - %v", sourceBucket, info.Err), "bucket", sourceBucket, "error", info.Err.Error())
+				continue
+			}
+			for _, event := range info.Records {
+				object, err := eventObject(event)
+				if err != nil {
+					slog.Warn(fmt.Sprintf("Failed to parse notification: %v - %v", event.S3.Object.Key, err), "key", event.S3.Object.Key, "error", err.Error())
+					continue
+				}
+				selected, err := selectObject(ctx, src, object, true)
+				if err != nil {
+					slog.Error(fmt.Sprintf("Failed to select object: %v - %v", object.Key, err), "key", object.Key, "error", err.Error())
+					continue
+				}
+				if !selected {
+					continue
+				}
+				log.Printf("Created: %v", object.Key)
+				if rotateInterval > 0 {
+					// Close the previous window should its timer not have fired yet
+					if start := windowStart(time.Now()); !start.Equal(window) {
+						flush(ctx)
+						window = start
+					}
+					if len(pending) == 0 {
+						resetTimer(timer, time.Until(window.Add(rotateInterval)))
+					}
+				} else if len(pending) == 0 {
+					resetTimer(timer, flushInterval)
+				}
+				pending = append(pending, object)
+				pendingSize += object.Size
+				if pendingSize >= int64(flushSize) && !time.Now().Before(retryAt) {
+					flush(ctx)
+				}
+			}
+		}
+	}
+}
+
+// Stop the timer, draining a fire not yet received so that it does not trigger a flush once reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// Reset the timer to fire after d, dropping a fire not yet received
+func resetTimer(timer *time.Timer, d time.Duration) {
+	stopTimer(timer)
+	timer.Reset(d)
+}
+
+// Return the start of the rotate-interval window containing t, aligned in the timestamp-timezone
+func windowStart(t time.Time) time.Time {
+	_, offset := t.In(timestampLocation).Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(rotateInterval).Add(-shift).UTC()
+}
+
+// Append the given source objects into a new resulting object
+func flushObjects(ctx context.Context, src source, target sink, objects []minio.ObjectInfo) (err error) {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "run", "source", sourceBucketPrefix)
+	defer func() {
+		endRun(span, start, err)
+		writeAudit(target, err)
+		notifyRun(err)
+	}()
+	err = claimTargetName(ctx, target)
+	if err != nil {
+		return err
+	}
+	err = streamObject(ctx, target, func(w io.Writer, rotate rotation) error {
+		queue := make(chan minio.ObjectInfo, len(objects))
+		for _, object := range objects {
+			queue <- object
+		}
+		close(queue)
+		return appendObjects(ctx, src, queue, w, nil)
+	})
+	if err == nil {
+		err = nameTarget(ctx, target)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to flush %v objects to %v - %v", len(objects), targetObjectName, err), "objects", len(objects), "object", targetObjectName, "error", err.Error())
+		return err
+	}
+	finishRun(ctx, target)
+	return nil
+}
+
+// Return the source object described by a bucket notification
+func eventObject(event notification.Event) (minio.ObjectInfo, error) {
+	key, err := url.QueryUnescape(event.S3.Object.Key)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	lastModified, err := time.Parse(time.RFC3339, event.EventTime)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return minio.ObjectInfo{
+		Key:          key,
+		Size:         event.S3.Object.Size,
+		ETag:         event.S3.Object.ETag,
+		ContentType:  event.S3.Object.ContentType,
+		LastModified: lastModified,
+	}, nil
+}
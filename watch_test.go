@@ -0,0 +1,75 @@
+// Copyright (c) 2015-2024 MinIO, Inc.
+//
+// This file is part of MinIO Object Storage stack
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowStart(t *testing.T) {
+	defer func(location *time.Location, interval time.Duration) {
+		timestampLocation, rotateInterval = location, interval
+	}(timestampLocation, rotateInterval)
+	india := time.FixedZone("IST", 5*3600+1800)
+	for _, tt := range []struct {
+		location *time.Location
+		interval time.Duration
+		t, want  string
+	}{
+		{time.UTC, time.Hour, "2024-02-26T10:30:00Z", "2024-02-26T10:00:00Z"},
+		{time.UTC, time.Hour, "2024-02-26T10:00:00Z", "2024-02-26T10:00:00Z"},
+		{time.UTC, time.Hour, "2024-02-26T10:59:59.999Z", "2024-02-26T10:00:00Z"},
+		{time.UTC, 15 * time.Minute, "2024-02-26T10:44:59Z", "2024-02-26T10:30:00Z"},
+		{time.UTC, 24 * time.Hour, "2024-02-26T23:59:59Z", "2024-02-26T00:00:00Z"},
+		{time.UTC, 24 * time.Hour, "2024-02-27T00:00:00Z", "2024-02-27T00:00:00Z"},
+		{india, time.Hour, "2024-02-26T10:15:00Z", "2024-02-26T09:30:00Z"},
+		{india, 24 * time.Hour, "2024-02-26T18:30:00Z", "2024-02-26T18:30:00Z"},
+		{india, 24 * time.Hour, "2024-02-26T18:29:59Z", "2024-02-25T18:30:00Z"},
+		{india, 24 * time.Hour, "2024-02-26T20:00:00+01:00", "2024-02-26T18:30:00Z"},
+	} {
+		timestampLocation, rotateInterval = tt.location, tt.interval
+		at, err := time.Parse(time.RFC3339Nano, tt.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := time.Parse(time.RFC3339Nano, tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := windowStart(at); !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("%v window in %v of %v = %v, want %v", tt.interval, tt.location, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestResetTimerDropsStaleFire(t *testing.T) {
+	timer := time.NewTimer(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	resetTimer(timer, time.Hour)
+	select {
+	case <-timer.C:
+		t.Error("timer fired from before it was reset")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Stopping a timer whose fire was received does not block
+	timer.Reset(time.Millisecond)
+	<-timer.C
+	stopTimer(timer)
+}